@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+// benchBaseline is the content of a --bench-save file.
+type benchBaseline struct {
+	Created time.Time             `json:"created"`
+	Target  string                `json:"target"` // Image or endpoint the latencies were measured against
+	Tests   map[string]benchStats `json:"tests"`  // Suite path and test name -> latencies
+}
+
+// benchStats summarizes the latencies of one test, in milliseconds.
+type benchStats struct {
+	Runs     int     `json:"runs"`
+	Failures int     `json:"failures"`
+	Min      float64 `json:"min_ms"`
+	P50      float64 `json:"p50_ms"`
+	P95      float64 `json:"p95_ms"`
+	P99      float64 `json:"p99_ms"`
+	Max      float64 `json:"max_ms"`
+}
+
+// newBenchStats summarizes durations.
+func newBenchStats(durations []time.Duration, failures int) benchStats {
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	return benchStats{
+		Runs:     len(sorted),
+		Failures: failures,
+		Min:      milliseconds(percentile(sorted, 0)),
+		P50:      milliseconds(percentile(sorted, 50)),
+		P95:      milliseconds(percentile(sorted, 95)),
+		P99:      milliseconds(percentile(sorted, 99)),
+		Max:      milliseconds(percentile(sorted, 100)),
+	}
+}
+
+// percentile returns the p-th percentile of sorted durations, by the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank-1, 0), len(sorted)-1)]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// loadBenchBaseline reads a --bench-save file.
+func loadBenchBaseline(path string) (*benchBaseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bench baseline: %w", err)
+	}
+	var baseline benchBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("failed to parse bench baseline %s: %w", path, err)
+	}
+	return &baseline, nil
+}
+
+// printBench prints the latencies of each benchmarked test and, with a
+// baseline, how its p50 and p95 compare. It returns the number of tests
+// whose p95 is more than tolerance (a fraction) over the baseline.
+func printBench(stats map[string]benchStats, baseline *benchBaseline, tolerance float64) int {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("\n========================================\n")
+	fmt.Printf("Latency (ms)\n")
+	fmt.Printf("========================================\n")
+	if baseline != nil {
+		fmt.Printf("Baseline: %s, measured %s\n", baseline.Target, baseline.Created.Local().Format(time.DateTime))
+	}
+	fmt.Printf("  %5s %5s %9s %9s %9s %9s %9s", "runs", "fail", "min", "p50", "p95", "p99", "max")
+	if baseline != nil {
+		fmt.Printf(" %8s %8s", "p50 chg", "p95 chg")
+	}
+	fmt.Printf("  test\n")
+
+	regressions := 0
+	for _, name := range names {
+		s := stats[name]
+		fmt.Printf("  %5d %5d %9.1f %9.1f %9.1f %9.1f %9.1f", s.Runs, s.Failures, s.Min, s.P50, s.P95, s.P99, s.Max)
+		if baseline != nil {
+			base, ok := baseline.Tests[name]
+			switch {
+			case !ok:
+				fmt.Printf(" %8s %8s", "new", "new")
+			default:
+				fmt.Printf(" %8s %8s", change(s.P50, base.P50), change(s.P95, base.P95))
+				if regressed(s, base, tolerance) {
+					regressions++
+					fmt.Printf("  %s  REGRESSED\n", name)
+					continue
+				}
+			}
+		}
+		fmt.Printf("  %s\n", name)
+	}
+	if baseline != nil {
+		fmt.Printf("\n%d regression(s): p95 more than %.0f%% over the baseline\n", regressions, tolerance*100)
+	}
+	return regressions
+}
+
+// regressed reports whether the p95 of s is more than tolerance (a
+// fraction) over that of base. A baseline without a p95 never regresses.
+func regressed(s, base benchStats, tolerance float64) bool {
+	return base.P95 > 0 && s.P95 > base.P95*(1+tolerance)
+}
+
+// change formats the relative change from base to v.
+func change(v, base float64) string {
+	if base == 0 {
+		return "-"
+	}
+	pct := math.Round((v - base) / base * 100)
+	if pct == 0 {
+		pct = 0 // Not -0
+	}
+	return fmt.Sprintf("%+.0f%%", pct)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ms := func(n ...int) []time.Duration {
+		var d []time.Duration
+		for _, v := range n {
+			d = append(d, time.Duration(v)*time.Millisecond)
+		}
+		return d
+	}
+	hundred := make([]int, 100)
+	for i := range hundred {
+		hundred[i] = i + 1
+	}
+
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{name: "empty", p: 50, want: 0},
+		{name: "one run", sorted: ms(7), p: 99, want: 7 * time.Millisecond},
+		{name: "min", sorted: ms(1, 2, 3, 4), p: 0, want: 1 * time.Millisecond},
+		{name: "max", sorted: ms(1, 2, 3, 4), p: 100, want: 4 * time.Millisecond},
+		{name: "median of even count is the lower", sorted: ms(1, 2, 3, 4), p: 50, want: 2 * time.Millisecond},
+		{name: "median of odd count", sorted: ms(1, 2, 3, 4, 5), p: 50, want: 3 * time.Millisecond},
+		{name: "nearest rank rounds up", sorted: ms(1, 2, 3, 4, 5), p: 95, want: 5 * time.Millisecond},
+		{name: "p95 of 100", sorted: ms(hundred...), p: 95, want: 95 * time.Millisecond},
+		{name: "p99 of 100", sorted: ms(hundred...), p: 99, want: 99 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile(%v, %v) = %v, want %v", tt.sorted, tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBenchStats(t *testing.T) {
+	durations := []time.Duration{
+		40 * time.Millisecond,
+		10 * time.Millisecond,
+		30 * time.Millisecond,
+		20*time.Millisecond + 500*time.Microsecond,
+	}
+	got := newBenchStats(durations, 1)
+	want := benchStats{Runs: 4, Failures: 1, Min: 10, P50: 20.5, P95: 40, P99: 40, Max: 40}
+	if got != want {
+		t.Errorf("newBenchStats = %+v, want %+v", got, want)
+	}
+	if durations[0] != 40*time.Millisecond {
+		t.Error("newBenchStats sorted its argument")
+	}
+	if empty := newBenchStats(nil, 0); empty != (benchStats{}) {
+		t.Errorf("newBenchStats(nil) = %+v, want zero stats", empty)
+	}
+}
+
+func TestRegressed(t *testing.T) {
+	tests := []struct {
+		name      string
+		p95, base float64
+		tolerance float64
+		want      bool
+	}{
+		{name: "faster", p95: 8, base: 10, tolerance: 0.2},
+		{name: "within tolerance", p95: 11.9, base: 10, tolerance: 0.2},
+		{name: "at tolerance", p95: 12, base: 10, tolerance: 0.2},
+		{name: "over tolerance", p95: 12.1, base: 10, tolerance: 0.2, want: true},
+		{name: "no tolerance", p95: 10.1, base: 10, want: true},
+		{name: "baseline without p95", p95: 5, base: 0, tolerance: 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := regressed(benchStats{P95: tt.p95}, benchStats{P95: tt.base}, tt.tolerance)
+			if got != tt.want {
+				t.Errorf("regressed(p95 %v, base %v, tolerance %v) = %v, want %v", tt.p95, tt.base, tt.tolerance, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintBenchCountsRegressions(t *testing.T) {
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+
+	stats := map[string]benchStats{
+		"fixtures/ledger/001_Read":  {Runs: 10, P95: 13},
+		"fixtures/ledger/002_List":  {Runs: 10, P95: 10},
+		"fixtures/ledger/003_Query": {Runs: 10, P95: 50},
+	}
+	baseline := &benchBaseline{Tests: map[string]benchStats{
+		"fixtures/ledger/001_Read": {Runs: 10, P95: 10},
+		"fixtures/ledger/002_List": {Runs: 10, P95: 10},
+		// 003_Query is new, so it cannot regress
+	}}
+
+	if got := printBench(stats, baseline, 0.2); got != 1 {
+		t.Errorf("printBench = %d regressions, want 1", got)
+	}
+	if got := printBench(stats, baseline, 0.5); got != 0 {
+		t.Errorf("printBench with a 50%% tolerance = %d regressions, want 0", got)
+	}
+	if got := printBench(stats, nil, 0.2); got != 0 {
+		t.Errorf("printBench without a baseline = %d regressions, want 0", got)
+	}
+}
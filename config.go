@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is looked up in the working directory when --config is
+// not given.
+const defaultConfigFile = "test-runner.yaml"
+
+// settings holds every run setting that can come from a flag or the config file.
+type settings struct {
+	suitePaths     stringSlice
+	headers        stringSlice
+	verbose        bool
+	failFast       bool
+	endpoint       string
+	adminEndpoint  string
+	grpcEndpoint   string
+	image          string
+	pull           bool
+	reuseContainer bool
+	keepOnFailure  bool
+	parallel       int
+	parallelTests  int
+	containers     int
+	count          int
+	retries        int
+	watch          bool
+	shard          string
+	timings        string
+	bench          int
+	benchSave      string
+	benchBaseline  string
+	benchTolerance float64
+	summary        bool
+	record         string
+	replay         string
+	timeout        time.Duration
+	jqLibrary      string
+
+	containerEnv     stringSlice
+	containerFiles   stringSlice
+	containerMemory  string
+	containerCPUs    float64
+	containerNetwork string
+	containerAliases stringSlice
+	startupTimeout   time.Duration
+	healthPath       string
+	containerLogs    string
+}
+
+// runConfig mirrors settings in the config file. Pointer fields distinguish
+// "unset" from zero values so profiles can switch a base setting off.
+type runConfig struct {
+	TestSuitePaths []string          `yaml:"test_suite_paths"`
+	Headers        map[string]string `yaml:"headers"`
+	Verbose        *bool             `yaml:"verbose"`
+	FailFast       *bool             `yaml:"fail_fast"`
+	Endpoint       *string           `yaml:"endpoint"`
+	AdminEndpoint  *string           `yaml:"admin_endpoint"`
+	GRPCEndpoint   *string           `yaml:"grpc_endpoint"`
+	Image          *string           `yaml:"image"`
+	Pull           *bool             `yaml:"pull"`
+	ReuseContainer *bool             `yaml:"reuse_container"`
+	KeepOnFailure  *bool             `yaml:"keep_on_failure"`
+	Parallel       *int              `yaml:"parallel"`
+	ParallelTests  *int              `yaml:"parallel_tests"`
+	Containers     *int              `yaml:"containers"`
+	Count          *int              `yaml:"count"`
+	Retries        *int              `yaml:"retries"`
+	Watch          *bool             `yaml:"watch"`
+	Shard          *string           `yaml:"shard"`
+	Timings        *string           `yaml:"timings"`
+	Bench          *int              `yaml:"bench"`
+	BenchSave      *string           `yaml:"bench_save"`
+	BenchBaseline  *string           `yaml:"bench_baseline"`
+	BenchTolerance *float64          `yaml:"bench_tolerance"`
+	Summary        *bool             `yaml:"summary"`
+	Record         *string           `yaml:"record"`
+	Replay         *string           `yaml:"replay"`
+	Timeout        *duration         `yaml:"timeout"`
+	JQLibrary      *string           `yaml:"jq_library"`
+	Container      containerConfig   `yaml:"container"`
+}
+
+// containerConfig is the container section of the config file.
+type containerConfig struct {
+	Env            map[string]string `yaml:"env"`
+	Files          map[string]string `yaml:"files"` // container path -> host path
+	Memory         *string           `yaml:"memory"`
+	CPUs           *float64          `yaml:"cpus"`
+	Network        *string           `yaml:"network"`
+	NetworkAliases []string          `yaml:"network_aliases"`
+	StartupTimeout *duration         `yaml:"startup_timeout"`
+	HealthPath     *string           `yaml:"health_path"`
+	Logs           *string           `yaml:"logs"`
+}
+
+// fileConfig is the top-level layout of test-runner.yaml: base settings plus
+// named profiles layered on top of them.
+type fileConfig struct {
+	runConfig `yaml:",inline"`
+	Profiles  map[string]runConfig `yaml:"profiles"`
+}
+
+// duration unmarshals Go duration strings such as "45s" from YAML.
+type duration time.Duration
+
+func (d *duration) UnmarshalYAML(node *yaml.Node) error {
+	v, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q: %w", node.Line, node.Value, err)
+	}
+	*d = duration(v)
+	return nil
+}
+
+// loadConfig reads the config file at path and resolves the named profile.
+// An empty path looks for test-runner.yaml in the working directory and
+// returns nil if there is none. Relative paths in the file are resolved
+// against the file's directory.
+func loadConfig(path, profile string) (*runConfig, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			if profile != "" {
+				return nil, fmt.Errorf("--profile %q given but no %s found", profile, defaultConfigFile)
+			}
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var fc fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config %q: %w", path, err)
+	}
+
+	cfg := fc.runConfig
+	if profile != "" {
+		p, ok := fc.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %q not found in %q (available: %s)", profile, path, strings.Join(profileNames(fc.Profiles), ", "))
+		}
+		cfg.overlay(p)
+	}
+
+	cfg.resolvePaths(filepath.Dir(path))
+	return &cfg, nil
+}
+
+// overlay copies every setting present in o over c. Headers, container
+// env and container files are merged.
+func (c *runConfig) overlay(o runConfig) {
+	if len(o.TestSuitePaths) > 0 {
+		c.TestSuitePaths = o.TestSuitePaths
+	}
+	c.Headers = mergeMaps(c.Headers, o.Headers)
+	overlayPtr(&c.Verbose, o.Verbose)
+	overlayPtr(&c.FailFast, o.FailFast)
+	overlayPtr(&c.Endpoint, o.Endpoint)
+	overlayPtr(&c.AdminEndpoint, o.AdminEndpoint)
+	overlayPtr(&c.GRPCEndpoint, o.GRPCEndpoint)
+	overlayPtr(&c.Image, o.Image)
+	overlayPtr(&c.Pull, o.Pull)
+	overlayPtr(&c.ReuseContainer, o.ReuseContainer)
+	overlayPtr(&c.KeepOnFailure, o.KeepOnFailure)
+	overlayPtr(&c.Parallel, o.Parallel)
+	overlayPtr(&c.ParallelTests, o.ParallelTests)
+	overlayPtr(&c.Containers, o.Containers)
+	overlayPtr(&c.Count, o.Count)
+	overlayPtr(&c.Retries, o.Retries)
+	overlayPtr(&c.Watch, o.Watch)
+	overlayPtr(&c.Shard, o.Shard)
+	overlayPtr(&c.Timings, o.Timings)
+	overlayPtr(&c.Bench, o.Bench)
+	overlayPtr(&c.BenchSave, o.BenchSave)
+	overlayPtr(&c.BenchBaseline, o.BenchBaseline)
+	overlayPtr(&c.BenchTolerance, o.BenchTolerance)
+	overlayPtr(&c.Summary, o.Summary)
+	overlayPtr(&c.Record, o.Record)
+	overlayPtr(&c.Replay, o.Replay)
+	overlayPtr(&c.Timeout, o.Timeout)
+	overlayPtr(&c.JQLibrary, o.JQLibrary)
+
+	c.Container.Env = mergeMaps(c.Container.Env, o.Container.Env)
+	c.Container.Files = mergeMaps(c.Container.Files, o.Container.Files)
+	overlayPtr(&c.Container.Memory, o.Container.Memory)
+	overlayPtr(&c.Container.CPUs, o.Container.CPUs)
+	overlayPtr(&c.Container.Network, o.Container.Network)
+	if len(o.Container.NetworkAliases) > 0 {
+		c.Container.NetworkAliases = o.Container.NetworkAliases
+	}
+	overlayPtr(&c.Container.StartupTimeout, o.Container.StartupTimeout)
+	overlayPtr(&c.Container.HealthPath, o.Container.HealthPath)
+	overlayPtr(&c.Container.Logs, o.Container.Logs)
+}
+
+// resolvePaths makes relative file paths relative to dir.
+func (c *runConfig) resolvePaths(dir string) {
+	for i, p := range c.TestSuitePaths {
+		c.TestSuitePaths[i] = resolvePath(dir, p)
+	}
+	if c.Record != nil {
+		*c.Record = resolvePath(dir, *c.Record)
+	}
+	if c.Replay != nil {
+		*c.Replay = resolvePath(dir, *c.Replay)
+	}
+	if c.JQLibrary != nil {
+		*c.JQLibrary = resolvePath(dir, *c.JQLibrary)
+	}
+	if c.Timings != nil {
+		*c.Timings = resolvePath(dir, *c.Timings)
+	}
+	if c.BenchSave != nil {
+		*c.BenchSave = resolvePath(dir, *c.BenchSave)
+	}
+	if c.BenchBaseline != nil {
+		*c.BenchBaseline = resolvePath(dir, *c.BenchBaseline)
+	}
+	if c.Container.Logs != nil {
+		*c.Container.Logs = resolvePath(dir, *c.Container.Logs)
+	}
+	for containerPath, hostPath := range c.Container.Files {
+		c.Container.Files[containerPath] = resolvePath(dir, hostPath)
+	}
+}
+
+// applyTo fills s from the config for every flag not set on the command line.
+// Config headers are always included; command-line headers win on conflict.
+func (c *runConfig) applyTo(s *settings, explicit map[string]bool) {
+	if !explicit["test_suite_path"] {
+		s.suitePaths = append(s.suitePaths, c.TestSuitePaths...)
+	}
+	if len(c.Headers) > 0 {
+		var headers stringSlice
+		for _, k := range sortedKeys(c.Headers) {
+			headers = append(headers, k+": "+c.Headers[k])
+		}
+		s.headers = append(headers, s.headers...)
+	}
+	// Like headers, config env and files come first so the command line wins
+	var env, files stringSlice
+	for _, k := range sortedKeys(c.Container.Env) {
+		env = append(env, k+"="+c.Container.Env[k])
+	}
+	for _, k := range sortedKeys(c.Container.Files) {
+		files = append(files, c.Container.Files[k]+":"+k)
+	}
+	s.containerEnv = append(env, s.containerEnv...)
+	s.containerFiles = append(files, s.containerFiles...)
+	applyPtr(&s.verbose, c.Verbose, explicit["verbose"])
+	applyPtr(&s.failFast, c.FailFast, explicit["fail-fast"])
+	applyPtr(&s.endpoint, c.Endpoint, explicit["endpoint"])
+	applyPtr(&s.adminEndpoint, c.AdminEndpoint, explicit["admin-endpoint"])
+	applyPtr(&s.grpcEndpoint, c.GRPCEndpoint, explicit["grpc-endpoint"])
+	applyPtr(&s.image, c.Image, explicit["image"])
+	applyPtr(&s.pull, c.Pull, explicit["pull"])
+	applyPtr(&s.reuseContainer, c.ReuseContainer, explicit["reuse-container"])
+	applyPtr(&s.keepOnFailure, c.KeepOnFailure, explicit["keep-on-failure"])
+	applyPtr(&s.parallel, c.Parallel, explicit["parallel"])
+	applyPtr(&s.parallelTests, c.ParallelTests, explicit["parallel-tests"])
+	applyPtr(&s.containers, c.Containers, explicit["containers"])
+	applyPtr(&s.count, c.Count, explicit["count"])
+	applyPtr(&s.retries, c.Retries, explicit["retries"])
+	applyPtr(&s.watch, c.Watch, explicit["watch"])
+	applyPtr(&s.shard, c.Shard, explicit["shard"])
+	applyPtr(&s.timings, c.Timings, explicit["timings"])
+	applyPtr(&s.bench, c.Bench, explicit["bench"])
+	applyPtr(&s.benchSave, c.BenchSave, explicit["bench-save"])
+	applyPtr(&s.benchBaseline, c.BenchBaseline, explicit["bench-baseline"])
+	applyPtr(&s.benchTolerance, c.BenchTolerance, explicit["bench-tolerance"])
+	applyPtr(&s.summary, c.Summary, explicit["summary"])
+	applyPtr(&s.record, c.Record, explicit["record"])
+	applyPtr(&s.replay, c.Replay, explicit["replay"])
+	applyPtr(&s.jqLibrary, c.JQLibrary, explicit["jq-library"])
+	if c.Timeout != nil && !explicit["timeout"] {
+		s.timeout = time.Duration(*c.Timeout)
+	}
+	applyPtr(&s.containerMemory, c.Container.Memory, explicit["container-memory"])
+	applyPtr(&s.containerCPUs, c.Container.CPUs, explicit["container-cpus"])
+	applyPtr(&s.containerNetwork, c.Container.Network, explicit["container-network"])
+	if len(c.Container.NetworkAliases) > 0 && !explicit["container-network-alias"] {
+		s.containerAliases = c.Container.NetworkAliases
+	}
+	if c.Container.StartupTimeout != nil && !explicit["startup-timeout"] {
+		s.startupTimeout = time.Duration(*c.Container.StartupTimeout)
+	}
+	applyPtr(&s.healthPath, c.Container.HealthPath, explicit["health-path"])
+	applyPtr(&s.containerLogs, c.Container.Logs, explicit["container-logs"])
+}
+
+func overlayPtr[T any](dst **T, src *T) {
+	if src != nil {
+		*dst = src
+	}
+}
+
+func applyPtr[T any](dst *T, src *T, explicit bool) {
+	if src != nil && !explicit {
+		*dst = *src
+	}
+}
+
+// mergeMaps returns base with over layered on top, without modifying either.
+func mergeMaps(base, over map[string]string) map[string]string {
+	if len(over) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		merged[k] = v
+	}
+	return merged
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func resolvePath(dir, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+func profileNames(profiles map[string]runConfig) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
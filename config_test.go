@@ -0,0 +1,190 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+test_suite_paths: [fixtures]
+endpoint: http://base
+parallel: 2
+timeout: 30s
+jq_library: lib
+timings: ci/timings.json
+headers:
+  X-Base: base
+  X-Shared: base
+container:
+  env:
+    LOG: info
+    MODE: base
+  files:
+    /seed.sql: seed.sql
+profiles:
+  ci:
+    parallel: 8
+    summary: true
+    headers:
+      X-Shared: ci
+    container:
+      env:
+        MODE: ci
+  quiet:
+    summary: false
+`
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"test-runner.yaml": testConfig,
+		"seed.sql":         "insert 1",
+		"override.sql":     "insert 2",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	override := filepath.Join(dir, "override.sql")
+
+	tests := []struct {
+		name    string
+		profile string
+		flags   settings // Values given on the command line
+		set     []string // Flags given on the command line
+		wantErr string
+
+		suitePaths []string
+		endpoint   string
+		parallel   int
+		summary    bool
+		timeout    time.Duration
+		headers    map[string]string
+		env        map[string]string
+		files      map[string]string // Container path -> host path
+	}{
+		{
+			name:       "base settings",
+			suitePaths: []string{filepath.Join(dir, "fixtures")},
+			endpoint:   "http://base",
+			parallel:   2,
+			timeout:    30 * time.Second,
+			headers:    map[string]string{"X-Base": "base", "X-Shared": "base"},
+			env:        map[string]string{"LOG": "info", "MODE": "base"},
+			files:      map[string]string{"/seed.sql": filepath.Join(dir, "seed.sql")},
+		},
+		{
+			name:       "profile beats base",
+			profile:    "ci",
+			suitePaths: []string{filepath.Join(dir, "fixtures")},
+			endpoint:   "http://base",
+			parallel:   8,
+			summary:    true,
+			timeout:    30 * time.Second,
+			headers:    map[string]string{"X-Base": "base", "X-Shared": "ci"},
+			env:        map[string]string{"LOG": "info", "MODE": "ci"},
+			files:      map[string]string{"/seed.sql": filepath.Join(dir, "seed.sql")},
+		},
+		{
+			name:    "flag beats profile",
+			profile: "ci",
+			flags: settings{
+				suitePaths:     stringSlice{"other"},
+				parallel:       3,
+				timeout:        time.Minute,
+				headers:        stringSlice{"X-Shared: cli"},
+				containerEnv:   stringSlice{"MODE=cli"},
+				containerFiles: stringSlice{override + ":/seed.sql"},
+			},
+			set:        []string{"test_suite_path", "parallel", "timeout", "header", "container-env", "container-file"},
+			suitePaths: []string{"other"},
+			endpoint:   "http://base",
+			parallel:   3,
+			summary:    true,
+			timeout:    time.Minute,
+			headers:    map[string]string{"X-Base": "base", "X-Shared": "cli"},
+			env:        map[string]string{"LOG": "info", "MODE": "cli"},
+			files:      map[string]string{"/seed.sql": override},
+		},
+		{
+			name:       "profile switches a setting off",
+			profile:    "quiet",
+			flags:      settings{summary: true},
+			suitePaths: []string{filepath.Join(dir, "fixtures")},
+			endpoint:   "http://base",
+			parallel:   2,
+			timeout:    30 * time.Second,
+			headers:    map[string]string{"X-Base": "base", "X-Shared": "base"},
+			env:        map[string]string{"LOG": "info", "MODE": "base"},
+			files:      map[string]string{"/seed.sql": filepath.Join(dir, "seed.sql")},
+		},
+		{
+			name:    "unknown profile",
+			profile: "nightly",
+			wantErr: `profile "nightly" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(filepath.Join(dir, "test-runner.yaml"), tt.profile)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			run := tt.flags
+			explicit := make(map[string]bool)
+			for _, name := range tt.set {
+				explicit[name] = true
+			}
+			cfg.applyTo(&run, explicit)
+
+			if !slices.Equal(run.suitePaths, tt.suitePaths) {
+				t.Errorf("suite paths = %q, want %q", run.suitePaths, tt.suitePaths)
+			}
+			if run.endpoint != tt.endpoint || run.parallel != tt.parallel || run.summary != tt.summary || run.timeout != tt.timeout {
+				t.Errorf("endpoint %q, parallel %d, summary %v, timeout %v; want %q, %d, %v and %v",
+					run.endpoint, run.parallel, run.summary, run.timeout, tt.endpoint, tt.parallel, tt.summary, tt.timeout)
+			}
+			if want := filepath.Join(dir, "lib"); run.jqLibrary != want {
+				t.Errorf("jq library = %q, want %q", run.jqLibrary, want)
+			}
+			if want := filepath.Join(dir, "ci", "timings.json"); run.timings != want {
+				t.Errorf("timings = %q, want %q", run.timings, want)
+			}
+
+			headers, err := parseHeaders(run.headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(headers, tt.headers) {
+				t.Errorf("headers = %v, want %v", headers, tt.headers)
+			}
+
+			opts, err := containerOptions(run)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(opts.Env, tt.env) {
+				t.Errorf("container env = %v, want %v", opts.Env, tt.env)
+			}
+			files := make(map[string]string)
+			for _, f := range opts.Files {
+				files[f.ContainerPath] = f.HostPath
+			}
+			if !maps.Equal(files, tt.files) || len(opts.Files) != len(tt.files) {
+				t.Errorf("container files = %v, want %v", opts.Files, tt.files)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/twisp/test-runner/runner"
+)
+
+// containerOptions builds the container settings from the run settings.
+func containerOptions(run settings) (runner.ContainerOptions, error) {
+	opts := runner.ContainerOptions{
+		Image:          run.image,
+		AlwaysPull:     run.pull,
+		CPUs:           run.containerCPUs,
+		Network:        run.containerNetwork,
+		NetworkAliases: run.containerAliases,
+		StartupTimeout: run.startupTimeout,
+		HealthPath:     run.healthPath,
+	}
+
+	for _, kv := range run.containerEnv {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return opts, fmt.Errorf("invalid container env %q (expected KEY=VALUE)", kv)
+		}
+		if opts.Env == nil {
+			opts.Env = make(map[string]string)
+		}
+		opts.Env[key] = value
+	}
+
+	for _, f := range run.containerFiles {
+		hostPath, containerPath, ok := strings.Cut(f, ":")
+		if !ok || hostPath == "" || !strings.HasPrefix(containerPath, "/") {
+			return opts, fmt.Errorf("invalid container file %q (expected HOST_PATH:/CONTAINER_PATH)", f)
+		}
+		absPath, err := filepath.Abs(hostPath)
+		if err != nil {
+			return opts, fmt.Errorf("invalid container file %q: %w", f, err)
+		}
+		if _, err := os.Stat(absPath); err != nil {
+			return opts, fmt.Errorf("container file: %w", err)
+		}
+		// A later file for the same container path, such as one from the
+		// command line over one from the config file, replaces the earlier
+		file := runner.ContainerFile{HostPath: absPath, ContainerPath: containerPath}
+		if i := slices.IndexFunc(opts.Files, func(f runner.ContainerFile) bool { return f.ContainerPath == containerPath }); i >= 0 {
+			opts.Files[i] = file
+			continue
+		}
+		opts.Files = append(opts.Files, file)
+	}
+
+	if run.containerMemory != "" {
+		memory, err := units.RAMInBytes(run.containerMemory)
+		if err != nil {
+			return opts, fmt.Errorf("invalid container memory %q: %w", run.containerMemory, err)
+		}
+		opts.Memory = memory
+	}
+	if run.containerCPUs < 0 {
+		return opts, fmt.Errorf("container cpus must be positive, got %v", run.containerCPUs)
+	}
+	if len(opts.NetworkAliases) > 0 && opts.Network == "" {
+		return opts, fmt.Errorf("container network aliases require a container network")
+	}
+	return opts, nil
+}
+
+// startContainers starts n Twisp containers concurrently. If any fails to
+// start, the ones that did start are terminated and the first error is
+// returned. With opts.Reuse, container i attaches to reuse slot i.
+func startContainers(ctx context.Context, n int, opts runner.ContainerOptions) ([]*runner.TwispContainer, error) {
+	containers := make([]*runner.TwispContainer, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slotOpts := opts
+			slotOpts.ReuseSlot = i
+			containers[i], errs[i] = runner.StartTwispContainer(ctx, slotOpts)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			terminateContainers(containers)
+			return nil, fmt.Errorf("container %d: %w", i+1, err)
+		}
+	}
+	return containers, nil
+}
+
+// terminateContainers stops every non-nil container, warning on failure.
+// It uses Background so a cancelled parent ctx still cleans up.
+func terminateContainers(containers []*runner.TwispContainer) {
+	for _, c := range containers {
+		if c == nil {
+			continue
+		}
+		if err := c.Terminate(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to terminate container: %v\n", err)
+		}
+	}
+}
+
+// runDown implements "test-runner down": it removes the containers left
+// running by --reuse-container.
+func runDown() int {
+	stopped, err := runner.StopReusedContainers(context.Background())
+	for _, name := range stopped {
+		fmt.Printf("Removed %s\n", name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(stopped) == 0 {
+		fmt.Println("No reused containers running")
+	}
+	return 0
+}
+
+// containerLogs names the captured logs of one container.
+type containerLogs struct {
+	name string
+	logs *runner.LogCapture
+}
+
+// testLogWindow is the span of a failed test, used to slice its container's
+// logs.
+type testLogWindow struct {
+	name       string
+	start, end time.Time
+	logs       *runner.LogCapture
+}
+
+// logSliceGrace extends each test's log window to catch lines that are
+// delivered shortly after the response.
+const logSliceGrace = 250 * time.Millisecond
+
+// writeContainerLogs writes the full logs of every container, followed by
+// the slice of logs written while each failed test ran. An empty path
+// writes to a new temporary file. It returns the path written.
+func writeContainerLogs(path string, containers []containerLogs, failed []testLogWindow) (string, error) {
+	var f *os.File
+	var err error
+	if path == "" {
+		f, err = os.CreateTemp("", "twisp-container-*.log")
+	} else {
+		f, err = os.Create(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, c := range containers {
+		fmt.Fprintf(w, "=== Logs: %s ===\n", c.name)
+		err := c.logs.Each(func(line runner.LogLine) error {
+			return runner.WriteLogLine(w, line)
+		})
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(w)
+	}
+	for _, t := range failed {
+		fmt.Fprintf(w, "=== Logs during failed test: %s (%s - %s) ===\n",
+			t.name, t.start.Format("15:04:05.000"), t.end.Format("15:04:05.000"))
+		lines, err := t.logs.Between(t.start, t.end.Add(logSliceGrace))
+		if err != nil {
+			return "", err
+		}
+		if err := runner.WriteLogLines(w, lines); err != nil {
+			return "", err
+		}
+		fmt.Fprintln(w)
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// keptSuite is a suite reported by --keep-on-failure.
+type keptSuite struct {
+	path      string
+	accountID string
+	failed    bool
+	container *runner.TwispContainer // nil with an external endpoint
+}
+
+// printKeptContainers prints how to reach each container left running by
+// --keep-on-failure and the account ID every suite used on it, so its
+// ledger state can be inspected.
+func printKeptContainers(suites []keptSuite, endpoint string) {
+	sort.Slice(suites, func(i, j int) bool { return suites[i].path < suites[j].path })
+	var order []*runner.TwispContainer
+	byContainer := make(map[*runner.TwispContainer][]keptSuite)
+	for _, s := range suites {
+		if _, ok := byContainer[s.container]; !ok {
+			order = append(order, s.container)
+		}
+		byContainer[s.container] = append(byContainer[s.container], s)
+	}
+
+	fmt.Printf("\n========================================\n")
+	fmt.Printf("Kept for debugging (--keep-on-failure)\n")
+	fmt.Printf("========================================\n")
+	for _, c := range order {
+		if c == nil {
+			fmt.Printf("Endpoint: %s\n", endpoint)
+		} else {
+			id := c.Container.GetContainerID()
+			fmt.Printf("Container %s\n", id[:min(12, len(id))])
+			fmt.Printf("  GraphQL: %s\n", c.GraphQLURL)
+			fmt.Printf("  Admin:   %s\n", c.AdminURL)
+			if c.Reused {
+				fmt.Printf("  Remove with: test-runner down\n")
+			} else {
+				fmt.Printf("  Remove with: docker rm -f %s\n", id[:min(12, len(id))])
+			}
+		}
+		for _, s := range byContainer[c] {
+			status := "PASS"
+			if s.failed {
+				status = "FAIL"
+			}
+			fmt.Printf("  %s  %s  X-Twisp-Account-Id: %s\n", status, s.path, s.accountID)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -33,8 +34,16 @@ func (s *stringSlice) Set(value string) error {
 }
 
 // hashSuitePath returns a SHA256 hash of the suite path for use as account ID.
-func hashSuitePath(path string) string {
-	h := sha256.Sum256([]byte(path))
+// The path is cleaned first so "./suite" and "suite" share a tenant, which
+// keeps --record files replayable regardless of how the path was spelled.
+// A non-empty salt yields a different tenant for the same path, so runs
+// against a long-lived server start from a clean slate.
+func hashSuitePath(path, salt string) string {
+	key := filepath.Clean(path)
+	if salt != "" {
+		key += "\x00" + salt
+	}
+	h := sha256.Sum256([]byte(key))
 	return hex.EncodeToString(h[:])
 }
 
@@ -97,30 +106,42 @@ func resolveGlobs(paths []string) ([]string, error) {
 	return resolved, nil
 }
 
-func expandSuitePaths(paths []string) ([]string, error) {
+// suiteRoot returns the path suitePath was found under, which its suite
+// config is inherited from, or suitePath itself if that is not known.
+func suiteRoot(roots map[string]string, suitePath string) string {
+	if root := roots[suitePath]; root != "" {
+		return root
+	}
+	return suitePath
+}
+
+// expandSuitePaths returns the runnable suites under paths, and for each the
+// path it was found under, which its suite config is inherited from.
+func expandSuitePaths(paths []string) ([]string, map[string]string, error) {
 	resolvedPaths, err := resolveGlobs(paths)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var expanded []string
+	roots := make(map[string]string)
 	seen := make(map[string]struct{})
 	for _, suitePath := range resolvedPaths {
 		info, err := os.Stat(suitePath)
 		if err != nil {
-			return nil, fmt.Errorf("invalid --test_suite_path %q: %w", suitePath, err)
+			return nil, nil, fmt.Errorf("invalid --test_suite_path %q: %w", suitePath, err)
 		}
 		if !info.IsDir() {
-			return nil, fmt.Errorf("test suite path %q is not a directory", suitePath)
+			return nil, nil, fmt.Errorf("test suite path %q is not a directory", suitePath)
 		}
 
 		suites, err := runner.DiscoverTests(suitePath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to discover suites under %q: %w", suitePath, err)
+			return nil, nil, fmt.Errorf("failed to discover suites under %q: %w", suitePath, err)
 		}
 		runnable := suites.RunnableSuitePaths()
 		if len(runnable) == 0 {
-			return nil, fmt.Errorf("no test suites found under %q", suitePath)
+			return nil, nil, fmt.Errorf("no test suites found under %q", suitePath)
 		}
 		for _, relPath := range runnable {
 			absPath := suitePath
@@ -132,34 +153,65 @@ func expandSuitePaths(paths []string) ([]string, error) {
 			}
 			seen[absPath] = struct{}{}
 			expanded = append(expanded, absPath)
+			roots[absPath] = suitePath
 		}
 	}
 	if len(expanded) == 0 {
-		return nil, fmt.Errorf("no test suites found")
+		return nil, nil, fmt.Errorf("no test suites found")
 	}
-	return expanded, nil
+	return expanded, roots, nil
 }
 
 func main() {
-	var suitePaths stringSlice
-	var headerFlags stringSlice
-	var verbose bool
-	var failFast bool
-	var endpoint string
-	var image string
-	var pull bool
-	var parallel int
-	var summary bool
-
-	flag.Var(&suitePaths, "test_suite_path", "Path to a test suite directory (can be specified multiple times)")
-	flag.BoolVar(&verbose, "verbose", false, "Print detailed output including response diffs")
-	flag.BoolVar(&failFast, "fail-fast", false, "Stop execution on first test failure")
-	flag.StringVar(&endpoint, "endpoint", "", "External GraphQL endpoint URL (skips container creation)")
-	flag.StringVar(&image, "image", runner.TwispImage, "Fully qualified Docker image to use for local container")
-	flag.BoolVar(&pull, "pull", false, "Always pull the container image before starting")
-	flag.Var(&headerFlags, "header", "Custom header in 'Key: Value' format (can be specified multiple times)")
-	flag.IntVar(&parallel, "parallel", 1, "Number of test suites to run concurrently against the shared endpoint (each suite uses a unique account ID)")
-	flag.BoolVar(&summary, "summary", false, "Suppress per-suite output; print only the final summary, runtimes, and any failures")
+	if len(os.Args) > 1 && os.Args[1] == "down" {
+		os.Exit(runDown())
+	}
+
+	var run settings
+	var configPath string
+	var profile string
+	var rerunFailed bool
+
+	flag.StringVar(&configPath, "config", "", "Path to a config file (default: "+defaultConfigFile+" in the working directory, if present)")
+	flag.StringVar(&profile, "profile", "", "Named profile from the config file to apply on top of its base settings")
+	flag.Var(&run.suitePaths, "test_suite_path", "Path to a test suite directory (can be specified multiple times)")
+	flag.BoolVar(&rerunFailed, "rerun-failed", false, "Run only the suites and tests that failed in the last run, plus the tests they build on")
+	flag.BoolVar(&run.verbose, "verbose", false, "Print detailed output including response diffs")
+	flag.BoolVar(&run.failFast, "fail-fast", false, "Stop execution on first test failure")
+	flag.StringVar(&run.endpoint, "endpoint", "", "External GraphQL endpoint URL (skips container creation)")
+	flag.StringVar(&run.adminEndpoint, "admin-endpoint", "", "Admin API base URL for request.admin.json steps when using --endpoint")
+	flag.StringVar(&run.grpcEndpoint, "grpc-endpoint", "", "gRPC server address (host:port) for request.grpc.json steps when using --endpoint")
+	flag.StringVar(&run.image, "image", runner.TwispImage, "Fully qualified Docker image to use for local container")
+	flag.BoolVar(&run.pull, "pull", false, "Always pull the container image before starting")
+	flag.BoolVar(&run.reuseContainer, "reuse-container", false, "Attach to a container left running by an earlier --reuse-container run, or start one and leave it running (remove with 'test-runner down')")
+	flag.BoolVar(&run.keepOnFailure, "keep-on-failure", false, "Leave containers running if any test fails and print their URLs and each suite's account ID")
+	flag.Var(&run.headers, "header", "Custom header in 'Key: Value' format (can be specified multiple times)")
+	flag.IntVar(&run.parallel, "parallel", 1, "Number of test suites to run concurrently against the shared endpoint (each suite uses a unique account ID)")
+	flag.IntVar(&run.parallelTests, "parallel-tests", 1, "Number of tests within a suite to run concurrently: unsequenced tests grouped with group_unsequenced, after the sequenced ones, or independent branches of tests with needs")
+	flag.IntVar(&run.count, "count", 1, "Run each suite this many times, each in a fresh tenant, and report tests that both passed and failed as flaky")
+	flag.IntVar(&run.retries, "retries", 0, "Retry a failed test up to this many times, each in a fresh tenant after replaying what it builds on; tests that pass on a retry are reported as flaky")
+	flag.BoolVar(&run.watch, "watch", false, "After the run, keep the container or endpoint up and rerun a suite in a fresh tenant whenever one of its fixture files changes")
+	flag.StringVar(&run.shard, "shard", "", "Run only shard i of n of the suites, given as 'i/n'; requires --timings, and shards are balanced by its durations when it has any")
+	flag.StringVar(&run.timings, "timings", filepath.Join(stateDir, timingsFile), "File of suite durations, updated with the suites each run completes and used by --shard and to queue the longest suites first")
+	flag.IntVar(&run.bench, "bench", 0, "After each suite's tests, run its read-only tests this many more times and report min, p50, p95, p99 and max latency")
+	flag.StringVar(&run.benchSave, "bench-save", "", "Save the --bench latencies to this file as a baseline")
+	flag.StringVar(&run.benchBaseline, "bench-baseline", "", "Compare the --bench latencies with a baseline saved by --bench-save; fail if a p95 regressed")
+	flag.Float64Var(&run.benchTolerance, "bench-tolerance", 0.2, "How much slower a p95 than in --bench-baseline counts as a regression, as a fraction")
+	flag.IntVar(&run.containers, "containers", 1, "Number of Twisp containers to start; suites are spread across them and --parallel is raised to at least this")
+	flag.BoolVar(&run.summary, "summary", false, "Suppress per-suite output; print only the final summary, runtimes, and any failures")
+	flag.StringVar(&run.record, "record", "", "Record all GraphQL request/response pairs to this file for later --replay")
+	flag.StringVar(&run.replay, "replay", "", "Serve responses from a --record file instead of starting a container (no Docker needed)")
+	flag.DurationVar(&run.timeout, "timeout", 30*time.Second, "Timeout for each GraphQL request")
+	flag.StringVar(&run.jqLibrary, "jq-library", "", "Directory of jq modules that transforms can import or include")
+	flag.Var(&run.containerEnv, "container-env", "Environment variable for the Twisp container in 'KEY=VALUE' format (can be specified multiple times)")
+	flag.Var(&run.containerFiles, "container-file", "Host file to copy into the Twisp container in 'HOST_PATH:CONTAINER_PATH' format (can be specified multiple times)")
+	flag.StringVar(&run.containerMemory, "container-memory", "", "Memory limit for the Twisp container, e.g. 2g (default: no limit)")
+	flag.Float64Var(&run.containerCPUs, "container-cpus", 0, "CPU limit for the Twisp container in cores, e.g. 1.5 (default: no limit)")
+	flag.StringVar(&run.containerNetwork, "container-network", "", "Existing Docker network to attach the Twisp container to")
+	flag.Var(&run.containerAliases, "container-network-alias", "Network alias for the Twisp container on --container-network (can be specified multiple times)")
+	flag.DurationVar(&run.startupTimeout, "startup-timeout", 2*time.Minute, "How long to wait for the Twisp container to become healthy")
+	flag.StringVar(&run.healthPath, "health-path", "/healthcheck", "HTTP path polled to decide the Twisp container is ready")
+	flag.StringVar(&run.containerLogs, "container-logs", "", "Write the Twisp container logs to this file (default: a temporary file, written only if tests fail)")
 
 	// Parse iteratively so we can sweep up positional args between flags.
 	// This lets unquoted shell globs work for --test_suite_path (the shell
@@ -174,34 +226,243 @@ func main() {
 		if len(positional) == 0 {
 			break
 		}
-		suitePaths = append(suitePaths, positional[0])
+		run.suitePaths = append(run.suitePaths, positional[0])
 		args = positional[1:]
 	}
 
-	if len(suitePaths) == 0 {
+	// Settings from the config file apply only where no flag was given.
+	// Positional suite paths count as --test_suite_path.
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	if len(run.suitePaths) > 0 {
+		explicit["test_suite_path"] = true
+	}
+	cfg, err := loadConfig(configPath, profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg != nil {
+		cfg.applyTo(&run, explicit)
+	}
+
+	if rerunFailed && explicit["test_suite_path"] {
+		fmt.Fprintln(os.Stderr, "Error: --rerun-failed cannot be combined with --test_suite_path")
+		os.Exit(1)
+	}
+	if len(run.suitePaths) == 0 && !rerunFailed {
 		fmt.Fprintln(os.Stderr, "Error: at least one --test_suite_path is required")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if parallel < 1 {
-		parallel = 1
+	if run.parallel < 1 {
+		run.parallel = 1
+	}
+	if run.parallelTests < 1 {
+		run.parallelTests = 1
+	}
+	if run.count < 1 {
+		run.count = 1
+	}
+	if run.retries < 0 {
+		run.retries = 0
+	}
+	if (run.benchSave != "" || run.benchBaseline != "") && run.bench < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --bench-save and --bench-baseline require --bench")
+		os.Exit(1)
+	}
+	if run.bench > 0 && run.replay != "" {
+		fmt.Fprintln(os.Stderr, "Error: --bench cannot be combined with --replay, whose latencies are those of the recording")
+		os.Exit(1)
+	}
+	// Suites and tests running alongside the benchmark would share the
+	// server with it and skew its latencies
+	if run.bench > 0 && (run.parallel > 1 || run.parallelTests > 1 || run.containers > 1) {
+		fmt.Fprintln(os.Stderr, "Note: --bench runs one suite and one test at a time; ignoring --parallel, --parallel-tests and --containers")
+		run.parallel, run.parallelTests, run.containers = 1, 1, 1
+	}
+	var baseline *benchBaseline
+	if run.benchBaseline != "" {
+		if baseline, err = loadBenchBaseline(run.benchBaseline); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	// Parse custom headers
-	headers, err := parseHeaders(headerFlags)
+	headers, err := parseHeaders(run.headers)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	expandedSuitePaths, err := expandSuitePaths([]string(suitePaths))
+	// --rerun-failed takes the suites, and the tests within them, from the
+	// last run. They are runnable suite paths already.
+	var expandedSuitePaths []string
+	var selection map[string][]string
+	var suiteRoots map[string]string
+	if rerunFailed {
+		last, err := loadLastRun()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(last.Failed) == 0 {
+			fmt.Println("No failures recorded by the last run")
+			os.Exit(0)
+		}
+		selection = make(map[string][]string)
+		suiteRoots = make(map[string]string)
+		for _, suite := range last.Failed {
+			if info, err := os.Stat(suite.Path); err != nil || !info.IsDir() {
+				fmt.Fprintf(os.Stderr, "Warning: suite %q from the last run no longer exists; skipping it\n", suite.Path)
+				continue
+			}
+			expandedSuitePaths = append(expandedSuitePaths, suite.Path)
+			selection[suite.Path] = suite.Tests
+			suiteRoots[suite.Path] = suite.Root
+		}
+		if len(expandedSuitePaths) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: none of the suites that failed in the last run exist")
+			os.Exit(1)
+		}
+	} else {
+		expandedSuitePaths, suiteRoots, err = expandSuitePaths([]string(run.suitePaths))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Durations of earlier runs balance shards. Every shard must split by
+	// the same durations, so they come from a file the CI job names rather
+	// than the one each checkout rewrites locally.
+	if run.shard != "" && !explicit["timings"] && (cfg == nil || cfg.Timings == nil) {
+		fmt.Fprintln(os.Stderr, "Error: --shard requires --timings, naming a timings file shared by all shards")
+		os.Exit(1)
+	}
+	timings, err := loadTimings(run.timings)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if run.shard != "" {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: ignoring timings: %v\n", err)
+		timings = &suiteTimings{Suites: make(map[string]float64)}
+	}
+
+	if run.shard != "" {
+		s, err := parseShard(run.shard)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		all := len(expandedSuitePaths)
+		digest := timingsDigest(expandedSuitePaths, timings)
+		var expected time.Duration
+		var balanced bool
+		expandedSuitePaths, expected, balanced = shardSuites(expandedSuitePaths, s, timings)
+		if balanced {
+			fmt.Printf("Shard %d/%d: %d of %d suite(s), about %v by recorded durations in %s (digest %s)\n", s.index, s.count, len(expandedSuitePaths), all, expected.Round(time.Second), run.timings, digest)
+		} else {
+			fmt.Printf("Shard %d/%d: %d of %d suite(s), assigned by path hash (no recorded durations in %s)\n", s.index, s.count, len(expandedSuitePaths), all, run.timings)
+		}
+		if len(expandedSuitePaths) == 0 {
+			fmt.Println("No suites in this shard")
+			os.Exit(0)
+		}
+	}
+
+	if run.replay != "" && (run.endpoint != "" || run.record != "") {
+		fmt.Fprintln(os.Stderr, "Error: --replay cannot be combined with --endpoint or --record")
+		os.Exit(1)
+	}
+	// A reused container gives every run fresh tenants, which a recording
+	// can never match
+	if run.reuseContainer && (run.record != "" || run.replay != "") {
+		fmt.Fprintln(os.Stderr, "Error: --record and --replay cannot be combined with --reuse-container")
+		os.Exit(1)
+	}
+
+	// Only GraphQL traffic is recorded, so admin API and gRPC steps could
+	// not be replayed
+	if run.record != "" || run.replay != "" {
+		for _, suitePath := range expandedSuitePaths {
+			suites, err := runner.DiscoverTestsUnder(suiteRoot(suiteRoots, suitePath), suitePath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to discover tests in %q: %v\n", suitePath, err)
+				os.Exit(1)
+			}
+			if requests := suites.NonGraphQLRequests(); len(requests) > 0 {
+				fmt.Fprintf(os.Stderr, "Error: --record and --replay only cover GraphQL requests, but suite %q has admin API or gRPC steps: %s\n", suitePath, strings.Join(requests, ", "))
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Suites whose suite.yaml sets isolated: true get a throwaway container
+	// of their own instead of a shared one.
+	isolatedSuites := make(map[string]bool)
+	for _, suitePath := range expandedSuitePaths {
+		suiteConfig, err := runner.LoadSuiteConfig(suiteRoots[suitePath], suitePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if suiteConfig.Isolated {
+			isolatedSuites[suitePath] = true
+		}
+	}
+
+	useExternalEndpoint := run.endpoint != "" || run.replay != ""
+
+	if run.containers < 1 {
+		run.containers = 1
+	}
+	if run.containers > 1 && useExternalEndpoint {
+		fmt.Fprintln(os.Stderr, "Error: --containers cannot be combined with --endpoint or --replay")
+		os.Exit(1)
+	}
+	if run.reuseContainer && useExternalEndpoint {
+		fmt.Fprintln(os.Stderr, "Error: --reuse-container cannot be combined with --endpoint or --replay")
+		os.Exit(1)
+	}
+	if run.containerLogs != "" && useExternalEndpoint {
+		fmt.Fprintln(os.Stderr, "Error: --container-logs cannot be combined with --endpoint or --replay")
 		os.Exit(1)
 	}
 
-	useExternalEndpoint := endpoint != ""
+	// A reused container keeps the data of earlier runs, so give every run
+	// fresh tenants. The reaper must be off or it would remove the container
+	// when we exit; it is read once, before the first container starts.
+	var accountSalt string
+	if run.reuseContainer {
+		accountSalt = strconv.FormatInt(time.Now().UnixNano(), 36)
+		os.Setenv("TESTCONTAINERS_RYUK_DISABLED", "true")
+	}
+	// Likewise for --keep-on-failure; containers are then terminated
+	// explicitly unless something failed.
+	if run.keepOnFailure {
+		os.Setenv("TESTCONTAINERS_RYUK_DISABLED", "true")
+	}
+
+	containerOpts, err := containerOptions(run)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	// Container logs are streamed to a file per container as they arrive,
+	// and collected into one file at the end. If the runner is killed they
+	// are left in this directory.
+	if !useExternalEndpoint {
+		if containerOpts.LogDir, err = os.MkdirTemp("", "twisp-container-logs-*"); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	// Set up context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
@@ -217,63 +478,146 @@ func main() {
 	}()
 
 	options := runner.Options{
-		Verbose:  verbose,
-		FailFast: failFast,
+		Verbose:       run.verbose,
+		FailFast:      run.failFast,
+		Timeout:       run.timeout,
+		JQLibrary:     run.jqLibrary,
+		ParallelTests: run.parallelTests,
+		Retries:       run.retries,
+		Bench:         run.bench,
 	}
 
-	if parallel > len(expandedSuitePaths) {
-		parallel = len(expandedSuitePaths)
+	// Every container gets at least one worker of its own. No shared
+	// container is needed if every suite brings its own.
+	sharedSuites := len(expandedSuitePaths) - len(isolatedSuites)
+	run.containers = min(run.containers, sharedSuites)
+	if run.watch && !useExternalEndpoint {
+		// Reruns need a container that stays up
+		run.containers = max(run.containers, 1)
+	}
+	if run.parallel < run.containers {
+		run.parallel = run.containers
+	}
+	if run.parallel > len(expandedSuitePaths)*run.count {
+		run.parallel = len(expandedSuitePaths) * run.count
 	}
-	buffered := parallel > 1
+	buffered := run.parallel > 1
 
 	runStart := time.Now()
 
-	// Start a single shared endpoint for all suites. Each suite uses a unique
-	// account ID (tenant), so they don't collide on the server.
-	var graphQLEndpoint string
+	// Start the shared endpoints. Each suite uses a unique account ID
+	// (tenant), so suites sharing an endpoint don't collide on the server.
+	// With --containers, worker i always talks to container i % N.
+	var graphQLEndpoints []string
+	if run.replay != "" {
+		replay, err := runner.StartReplayServer(run.replay)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting replay server: %v\n", err)
+			os.Exit(1)
+		}
+		defer replay.Close()
+		run.endpoint = replay.GraphQLURL
+	}
+
+	// Container logs are kept in memory and written out after the run if
+	// anything failed or --container-logs was given.
+	var (
+		capturedLogs []containerLogs
+		logsMu       sync.Mutex
+	)
+
+	var sharedContainers []*runner.TwispContainer
+
+	var recorder *runner.Recorder
+	if run.record != "" {
+		recorder = runner.NewRecorder(nil)
+	}
+
 	if useExternalEndpoint {
-		graphQLEndpoint = endpoint
+		graphQLEndpoints = []string{run.endpoint}
 		fmt.Printf("\n========================================\n")
-		fmt.Printf("Using external endpoint for %d suite(s)\n", len(expandedSuitePaths))
+		if run.replay != "" {
+			fmt.Printf("Replaying %s for %d suite(s)\n", run.replay, len(expandedSuitePaths))
+		} else {
+			fmt.Printf("Using external endpoint for %d suite(s)\n", len(expandedSuitePaths))
+		}
 		fmt.Printf("========================================\n")
-		fmt.Printf("Endpoint: %s\n", graphQLEndpoint)
-	} else {
+		fmt.Printf("Endpoint: %s\n", run.endpoint)
+	} else if run.containers > 0 {
 		fmt.Printf("\n========================================\n")
-		fmt.Printf("Starting shared container for %d suite(s)\n", len(expandedSuitePaths))
+		if run.containers > 1 {
+			fmt.Printf("Starting %d containers for %d suite(s)\n", run.containers, sharedSuites)
+		} else {
+			fmt.Printf("Starting shared container for %d suite(s)\n", sharedSuites)
+		}
 		fmt.Printf("========================================\n")
+		if len(isolatedSuites) > 0 {
+			fmt.Printf("%d isolated suite(s) will get a container of their own\n", len(isolatedSuites))
+		}
 
-		container, err := runner.StartTwispContainer(ctx, image, pull)
+		sharedOpts := containerOpts
+		sharedOpts.Reuse = run.reuseContainer
+		containers, err := startContainers(ctx, run.containers, sharedOpts)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error starting container: %v\n", err)
 			os.Exit(1)
 		}
-		// Use Background on terminate so a cancelled parent ctx still cleans up.
-		defer func() {
-			if termErr := container.Terminate(context.Background()); termErr != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to terminate container: %v\n", termErr)
+		sharedContainers = containers
+		defer func() { terminateContainers(sharedContainers) }()
+		for i, container := range containers {
+			graphQLEndpoints = append(graphQLEndpoints, container.GraphQLURL)
+			fmt.Printf("Container ready at: %s\n", container.GraphQLURL)
+			name := "shared container"
+			if len(containers) > 1 {
+				name = fmt.Sprintf("container %d", i+1)
 			}
-		}()
-		graphQLEndpoint = container.GraphQLURL
-		fmt.Printf("Container ready at: %s\n", graphQLEndpoint)
+			capturedLogs = append(capturedLogs, containerLogs{name: name, logs: container.Logs})
+		}
+		if run.reuseContainer {
+			fmt.Printf("Container(s) will be left running; remove with 'test-runner down'\n")
+		}
 	}
 
 	type testTiming struct {
-		path     string
+		path     string // Includes the run number with --count
+		test     string // Suite path and test name
+		dir      string // Test directory, relative to the suite
+		flaky    bool   // Passed on a retry
+		started  time.Time
 		duration time.Duration
 		passed   bool
 		errMsg   string
+		logs     *runner.LogCapture // Logs of the container the test ran against
 	}
 
 	type suiteOutcome struct {
-		path                    string
+		path                    string // Includes the run number with --count
+		suite                   string
 		passed, failed, skipped int
 		duration                time.Duration
 		tests                   []testTiming
 		runErr                  error
+		complete                bool // Not cut short by --fail-fast or an interrupt
+		accountID               string
+		container               *runner.TwispContainer // nil with an external endpoint
+		bench                   []*runner.BenchResult
 	}
 
-	jobs := make(chan string)
-	results := make(chan suiteOutcome, len(expandedSuitePaths))
+	// With --count, each suite is a job once per run. Run i of a suite uses
+	// its own tenant.
+	type suiteJob struct {
+		path      string
+		iteration int
+	}
+	label := func(path string, iteration int) string {
+		if run.count == 1 {
+			return path
+		}
+		return fmt.Sprintf("%s [run %d/%d]", path, iteration+1, run.count)
+	}
+
+	jobs := make(chan suiteJob)
+	results := make(chan suiteOutcome, len(expandedSuitePaths)*run.count)
 	var stdoutMu sync.Mutex
 	var wg sync.WaitGroup
 
@@ -286,81 +630,178 @@ func main() {
 		os.Stdout.Write(buf.Bytes())
 	}
 
-	worker := func() {
+	// --fail-fast stops the run, but not a --watch that follows it
+	runCtx, stopRun := context.WithCancel(ctx)
+	defer stopRun()
+
+	// Each worker's time spent waiting for a suite, including at the end
+	// while other workers finish
+	idle := make([]time.Duration, run.parallel)
+	workerDone := make([]time.Time, run.parallel)
+
+	worker := func(id int) {
 		defer wg.Done()
-		for suitePath := range jobs {
-			if ctx.Err() != nil {
+		defer func() { workerDone[id] = time.Now() }()
+		var graphQLEndpoint string
+		var workerContainer *runner.TwispContainer
+		adminURL, grpcAddr := run.adminEndpoint, run.grpcEndpoint
+		var workerLogs *runner.LogCapture
+		if len(graphQLEndpoints) > 0 {
+			graphQLEndpoint = graphQLEndpoints[id%len(graphQLEndpoints)]
+		}
+		if len(sharedContainers) > 0 {
+			workerContainer = sharedContainers[id%len(sharedContainers)]
+			workerLogs = workerContainer.Logs
+			adminURL, grpcAddr = workerContainer.AdminURL, workerContainer.GRPCAddr
+		}
+		for {
+			waitStart := time.Now()
+			job, ok := <-jobs
+			idle[id] += time.Since(waitStart)
+			if !ok {
+				break
+			}
+			suitePath, suiteLabel := job.path, label(job.path, job.iteration)
+			if runCtx.Err() != nil {
 				results <- suiteOutcome{}
 				continue
 			}
 
 			var buf *bytes.Buffer
-			if buffered && !summary {
+			var out io.Writer = os.Stdout
+			switch {
+			case run.summary:
+				out = io.Discard
+			case buffered:
 				buf = &bytes.Buffer{}
+				out = buf
 			}
 
-			accountID := hashSuitePath(suitePath)
-			r := runner.NewRunner(graphQLEndpoint, options, accountID, headers)
-			switch {
-			case summary:
-				r.SetOutput(io.Discard)
-			case buffered:
-				r.SetOutput(buf)
+			suiteEndpoint := graphQLEndpoint
+			suiteContainer := workerContainer
+			suiteAdminURL, suiteGRPCAddr := adminURL, grpcAddr
+			suiteLogs := workerLogs
+			var isolated *runner.TwispContainer
+			if isolatedSuites[suitePath] {
+				if useExternalEndpoint {
+					fmt.Fprintf(os.Stderr, "Warning: suite %q asks for an isolated container; running it against %s\n", suitePath, graphQLEndpoint)
+				} else {
+					fmt.Fprintf(out, "\nStarting isolated container for suite: %s\n", suitePath)
+					var err error
+					isolated, err = runner.StartTwispContainer(runCtx, containerOpts)
+					if err != nil {
+						flush(buf)
+						results <- suiteOutcome{path: suiteLabel, suite: suitePath, runErr: fmt.Errorf("starting isolated container for suite %q: %w", suitePath, err)}
+						continue
+					}
+					suiteEndpoint = isolated.GraphQLURL
+					suiteContainer = isolated
+					suiteAdminURL, suiteGRPCAddr = isolated.AdminURL, isolated.GRPCAddr
+					suiteLogs = isolated.Logs
+					logsMu.Lock()
+					capturedLogs = append(capturedLogs, containerLogs{name: "isolated container for " + suitePath, logs: isolated.Logs})
+					logsMu.Unlock()
+				}
+			}
+
+			salt := accountSalt
+			if job.iteration > 0 {
+				salt += "\x00" + strconv.Itoa(job.iteration)
+			}
+			accountID := hashSuitePath(suitePath, salt)
+			if run.count > 1 {
+				fmt.Fprintf(out, "\nRun %d of %d for suite: %s\n", job.iteration+1, run.count, suitePath)
+			}
+			r := runner.NewRunner(suiteEndpoint, options, accountID, headers)
+			if recorder != nil {
+				r.SetTransport(recorder)
+			}
+			r.SetServiceEndpoints(suiteAdminURL, suiteGRPCAddr)
+			r.SetOutput(out)
+			r.SelectTests(selection[suitePath])
+			r.SetSuiteRoot(suiteRoots[suitePath])
+			result, err := r.RunSuite(runCtx, suitePath)
+			r.Close()
+
+			// An isolated container is reported and kept for debugging
+			// along with the shared ones when its suite failed.
+			if isolated != nil && !(run.keepOnFailure && (err != nil || result.Failed > 0)) {
+				terminateContainers([]*runner.TwispContainer{isolated})
 			}
-			result, err := r.RunSuite(ctx, suitePath)
 
 			flush(buf)
 
 			if err != nil {
-				results <- suiteOutcome{path: suitePath, runErr: fmt.Errorf("running suite %q: %w", suitePath, err)}
+				results <- suiteOutcome{path: suiteLabel, suite: suitePath, runErr: fmt.Errorf("running suite %q: %w", suiteLabel, err), accountID: accountID, container: suiteContainer}
 				continue
 			}
 
 			tests := make([]testTiming, 0, len(result.Results))
 			for _, tr := range result.Results {
-				name := suitePath
-				if tr.Test != nil && tr.Test.Dir != "" {
-					name = filepath.Join(suitePath, tr.Test.Dir)
+				name, dir := suitePath, ""
+				if tr.Test != nil {
+					name, dir = filepath.Join(suitePath, tr.Name()), tr.Test.Dir
 				}
 				var errMsg string
 				if tr.Error != nil {
 					errMsg = tr.Error.Error()
 				}
 				tests = append(tests, testTiming{
-					path:     name,
+					path:     label(name, job.iteration),
+					test:     name,
+					dir:      dir,
+					flaky:    tr.Flaky,
+					started:  tr.Started,
 					duration: tr.Duration,
 					passed:   tr.Passed,
 					errMsg:   errMsg,
+					logs:     suiteLogs,
 				})
 			}
 
 			results <- suiteOutcome{
-				path:     suitePath,
-				passed:   result.Passed,
-				failed:   result.Failed,
-				skipped:  result.Skipped,
-				duration: result.Duration,
-				tests:    tests,
+				path:      suiteLabel,
+				suite:     suitePath,
+				passed:    result.Passed,
+				failed:    result.Failed,
+				skipped:   result.Skipped,
+				duration:  result.Duration,
+				tests:     tests,
+				accountID: accountID,
+				container: suiteContainer,
+				bench:     result.Bench,
+				complete:  runCtx.Err() == nil && !(run.failFast && result.Failed > 0),
 			}
 
-			if failFast && result.Failed > 0 {
-				cancel()
+			if run.failFast && result.Failed > 0 {
+				stopRun()
 			}
 		}
 	}
 
-	for i := 0; i < parallel; i++ {
+	// Within each --count iteration, queue the suites expected to take
+	// longest first, so a slow suite does not start last and hold up the run
+	queue := expandedSuitePaths
+	durations, scheduled := expectedDurations(expandedSuitePaths, timings)
+	if scheduled {
+		queue = longestFirst(expandedSuitePaths, durations)
+	}
+
+	poolStart := time.Now()
+	for i := 0; i < run.parallel; i++ {
 		wg.Add(1)
-		go worker()
+		go worker(i)
 	}
 
 	go func() {
 		defer close(jobs)
-		for _, suitePath := range expandedSuitePaths {
-			select {
-			case <-ctx.Done():
-				return
-			case jobs <- suitePath:
+		for i := range run.count {
+			for _, suitePath := range queue {
+				select {
+				case <-runCtx.Done():
+					return
+				case jobs <- suiteJob{path: suitePath, iteration: i}:
+				}
 			}
 		}
 	}()
@@ -368,6 +809,13 @@ func main() {
 	wg.Wait()
 	close(results)
 
+	poolEnd := time.Now()
+	var idleTotal time.Duration
+	for i := range idle {
+		idleTotal += idle[i] + poolEnd.Sub(workerDone[i])
+	}
+	workerTime := poolEnd.Sub(poolStart) * time.Duration(run.parallel)
+
 	totalPassed := 0
 	totalFailed := 0
 	totalSkipped := 0
@@ -389,9 +837,116 @@ func main() {
 
 	wallTime := time.Since(runStart)
 
+	// Remember what failed for --rerun-failed
+	failedTests := make(map[string][]string)
+	suiteErrors := make(map[string]error)
+	for _, o := range collectedSuites {
+		if o.runErr != nil {
+			suiteErrors[o.suite] = o.runErr
+			continue
+		}
+		for _, t := range o.tests {
+			if !t.passed {
+				failedTests[o.suite] = append(failedTests[o.suite], t.dir)
+			}
+		}
+	}
+	stateSaved := true
+	if path, err := saveLastRun(newLastRun(failedTests, suiteErrors, suiteRoots)); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write %s: %v\n", path, err)
+		stateSaved = false
+	}
+
+	// Record suite durations for --shard. Replayed runs say nothing about
+	// how long a suite takes against a real server, and --bench runs take
+	// longer than usual. Only suites that ran all of their tests count, so
+	// nothing is recorded for --rerun-failed or an interrupted run, nor for
+	// a suite --fail-fast stopped part way.
+	if run.timings != "" && run.replay == "" && run.bench == 0 && selection == nil && ctx.Err() == nil {
+		totals := make(map[string]time.Duration)
+		runs := make(map[string]int)
+		for _, o := range collectedSuites {
+			if o.runErr == nil && o.complete && o.duration > 0 {
+				totals[o.suite] += o.duration
+				runs[o.suite]++
+			}
+		}
+		durations := make(map[string]time.Duration, len(totals))
+		for suitePath, total := range totals {
+			durations[suitePath] = total / time.Duration(runs[suitePath])
+		}
+		if len(durations) > 0 {
+			if err := saveTimings(run.timings, durations); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to write %s: %v\n", run.timings, err)
+			}
+		}
+	}
+
+	if recorder != nil {
+		if err := recorder.Save(run.record); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to write recording %q: %v\n", run.record, err)
+		} else {
+			fmt.Printf("\nRecorded traffic written to %s\n", run.record)
+		}
+	}
+
+	if len(capturedLogs) > 0 && (run.containerLogs != "" || totalFailed > 0 || firstRunErr != nil) {
+		var failedWindows []testLogWindow
+		for _, t := range allTests {
+			if !t.passed && t.logs != nil {
+				failedWindows = append(failedWindows, testLogWindow{
+					name:  t.path,
+					start: t.started,
+					end:   t.started.Add(t.duration),
+					logs:  t.logs,
+				})
+			}
+		}
+		if path, err := writeContainerLogs(run.containerLogs, capturedLogs, failedWindows); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to write container logs: %v\n", err)
+		} else {
+			fmt.Printf("\nContainer logs written to %s\n", path)
+		}
+	}
+	if containerOpts.LogDir != "" {
+		removeLogs := func() {
+			for _, c := range capturedLogs {
+				c.logs.Close()
+			}
+			os.RemoveAll(containerOpts.LogDir)
+		}
+		// Containers kept for --watch go on logging until the watch ends
+		if run.watch {
+			defer removeLogs()
+		} else {
+			removeLogs()
+		}
+	}
+
+	if run.watch {
+		// The containers stay up for reruns until the watch ends
+	} else if run.keepOnFailure && (totalFailed > 0 || firstRunErr != nil) {
+		kept := make([]keptSuite, 0, len(collectedSuites))
+		for _, o := range collectedSuites {
+			kept = append(kept, keptSuite{
+				path:      o.path,
+				accountID: o.accountID,
+				failed:    o.failed > 0 || o.runErr != nil,
+				container: o.container,
+			})
+		}
+		printKeptContainers(kept, run.endpoint)
+		sharedContainers = nil
+	} else {
+		terminateContainers(sharedContainers)
+		sharedContainers = nil
+	}
+
 	if firstRunErr != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", firstRunErr)
-		os.Exit(1)
+		if !run.watch {
+			os.Exit(1)
+		}
 	}
 
 	// Failures, if any. Useful especially in --summary mode where per-test
@@ -412,6 +967,52 @@ func main() {
 				fmt.Printf("        %s\n", f.errMsg)
 			}
 		}
+		if stateSaved {
+			fmt.Printf("\nRun only these again with --rerun-failed\n")
+		}
+	}
+
+	// Flaky tests failed in some runs of --count and passed in others, or
+	// passed only on a retry.
+	type testRuns struct {
+		passed, failed, retried int
+	}
+	runsByTest := make(map[string]*testRuns)
+	for _, t := range allTests {
+		runs := runsByTest[t.test]
+		if runs == nil {
+			runs = &testRuns{}
+			runsByTest[t.test] = runs
+		}
+		switch {
+		case !t.passed:
+			runs.failed++
+		case t.flaky:
+			runs.passed++
+			runs.retried++
+		default:
+			runs.passed++
+		}
+	}
+	var flaky []string
+	for name, runs := range runsByTest {
+		if runs.retried > 0 || (runs.passed > 0 && runs.failed > 0) {
+			flaky = append(flaky, name)
+		}
+	}
+	sort.Strings(flaky)
+	if len(flaky) > 0 {
+		fmt.Printf("\n========================================\n")
+		fmt.Printf("Flaky tests (%d)\n", len(flaky))
+		fmt.Printf("========================================\n")
+		for _, name := range flaky {
+			runs := runsByTest[name]
+			detail := fmt.Sprintf("passed %d of %d runs", runs.passed, runs.passed+runs.failed)
+			if runs.retried > 0 {
+				detail += fmt.Sprintf(", %d only on a retry", runs.retried)
+			}
+			fmt.Printf("  FLAKY  %s  (%s)\n", name, detail)
+		}
 	}
 
 	// Per-suite timings, slowest first.
@@ -446,13 +1047,77 @@ func main() {
 		}
 	}
 
+	// Latencies of --bench, merged over the runs of --count
+	regressions := 0
+	if run.bench > 0 {
+		durations := make(map[string][]time.Duration)
+		failures := make(map[string]int)
+		for _, o := range collectedSuites {
+			for _, b := range o.bench {
+				name := filepath.Join(o.suite, b.Name)
+				durations[name] = append(durations[name], b.Durations...)
+				failures[name] += b.Failures
+			}
+		}
+		stats := make(map[string]benchStats, len(durations))
+		for name, d := range durations {
+			stats[name] = newBenchStats(d, failures[name])
+		}
+		if len(stats) == 0 {
+			fmt.Printf("\nNo read-only tests to benchmark; mark them with read_only: true in suite.yaml\n")
+		} else {
+			regressions = printBench(stats, baseline, run.benchTolerance)
+		}
+		if run.benchSave != "" && len(stats) > 0 {
+			target := run.endpoint
+			if target == "" {
+				target = run.image
+			}
+			saved := benchBaseline{Created: time.Now().UTC(), Target: target, Tests: stats}
+			if err := writeJSONFile(run.benchSave, saved); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to write %s: %v\n", run.benchSave, err)
+			} else {
+				fmt.Printf("\nBench baseline written to %s\n", run.benchSave)
+			}
+		}
+	}
+
 	// Print summary
 	fmt.Printf("\n========================================\n")
-	fmt.Printf("TOTAL: %d passed, %d failed, %d skipped\n", totalPassed, totalFailed, totalSkipped)
-	fmt.Printf("Wall time: %v  (parallel=%d)\n", wallTime.Round(time.Millisecond), parallel)
+	if len(flaky) > 0 {
+		fmt.Printf("TOTAL: %d passed, %d failed, %d skipped, %d flaky\n", totalPassed, totalFailed, totalSkipped, len(flaky))
+	} else {
+		fmt.Printf("TOTAL: %d passed, %d failed, %d skipped\n", totalPassed, totalFailed, totalSkipped)
+	}
+	if run.containers > 1 {
+		fmt.Printf("Wall time: %v  (parallel=%d, containers=%d)\n", wallTime.Round(time.Millisecond), run.parallel, run.containers)
+	} else {
+		fmt.Printf("Wall time: %v  (parallel=%d)\n", wallTime.Round(time.Millisecond), run.parallel)
+	}
+	if run.parallel > 1 && workerTime > 0 {
+		order := "suite path order"
+		if scheduled {
+			order = "longest first by recorded durations"
+		}
+		fmt.Printf("Worker idle: %v of %v  (%.0f%%, %s)\n", idleTotal.Round(time.Millisecond), workerTime.Round(time.Millisecond), 100*float64(idleTotal)/float64(workerTime), order)
+	}
 	fmt.Printf("========================================\n")
 
-	if totalFailed > 0 {
+	if run.watch {
+		var isolatedOpts *runner.ContainerOptions
+		if !useExternalEndpoint {
+			isolatedOpts = &containerOpts
+		}
+		target := func(suitePath string) watchTarget {
+			return newWatchTarget(suitePath, suiteRoot(suiteRoots, suitePath), run.jqLibrary)
+		}
+		watchSuites(ctx, expandedSuitePaths, target, func(suitePath string) {
+			rerunSuite(ctx, suitePath, suiteRoots[suitePath], accountSalt, run, options, headers, graphQLEndpoints[0], sharedContainers, isolatedOpts)
+		})
+		return
+	}
+
+	if totalFailed > 0 || regressions > 0 {
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,123 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// AdminRequestFile is the name of a test step that calls the admin API.
+const AdminRequestFile = "request.admin.json"
+
+// AdminRequest is the content of request.admin.json.
+type AdminRequest struct {
+	Method  string            `json:"method"`  // HTTP method (default GET, or POST when Body is set)
+	Path    string            `json:"path"`    // Path on the admin API, e.g. /metrics
+	Headers map[string]string `json:"headers"` // Extra request headers
+	Body    json.RawMessage   `json:"body"`    // Request body, sent as JSON
+}
+
+// AdminClient is an HTTP client for the Twisp admin API.
+type AdminClient struct {
+	baseURL    string
+	httpClient *http.Client
+	accountID  string
+	headers    map[string]string
+}
+
+// NewAdminClient creates a client for the admin API at baseURL. Requests
+// carry the tenant's account ID and the given headers, like GraphQL requests.
+func NewAdminClient(baseURL string, accountID string, headers map[string]string) *AdminClient {
+	return &AdminClient{
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		accountID: accountID,
+		headers:   headers,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// LoadAdminRequest reads and validates a request.admin.json file.
+func LoadAdminRequest(path string) (*AdminRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var req AdminRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if !strings.HasPrefix(req.Path, "/") {
+		return nil, fmt.Errorf("%s: path must start with /, got %q", path, req.Path)
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+		if len(req.Body) > 0 {
+			req.Method = http.MethodPost
+		}
+	}
+	req.Method = strings.ToUpper(req.Method)
+	return &req, nil
+}
+
+// Do sends the request and returns the response body as JSON. A body that
+// is not JSON, such as Prometheus metrics, is returned as a JSON string.
+// Headers override the defaults; headers passed to NewAdminClient still win.
+func (c *AdminClient) Do(ctx context.Context, adminReq *AdminRequest, headers map[string]string) ([]byte, error) {
+	var body io.Reader
+	if len(adminReq.Body) > 0 {
+		body = bytes.NewReader(adminReq.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, adminReq.Method, c.baseURL+adminReq.Path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-Twisp-Account-Id", c.accountID)
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	for key, value := range adminReq.Headers {
+		req.Header.Set(key, value)
+	}
+
+	// Apply custom headers (override defaults if same key)
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	if len(bytes.TrimSpace(respBody)) == 0 {
+		return []byte("null"), nil
+	}
+	if json.Valid(respBody) {
+		return respBody, nil
+	}
+	return json.Marshal(string(respBody))
+}
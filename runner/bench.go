@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// BenchResult holds the latencies of a read-only test run repeatedly for
+// Options.Bench.
+type BenchResult struct {
+	Name      string          // Test directory, followed by /case for a case
+	Durations []time.Duration // One per run, in run order
+	Failures  int             // Runs that did not pass
+}
+
+// bench runs each read-only test that passed Options.Bench more times and
+// records the latencies in result.Bench. Runs go one at a time, so they do
+// not slow each other down. The cases of a test are timed separately.
+func (r *Runner) bench(ctx context.Context, out io.Writer, result *SuiteResult, tests []*Test) {
+	failed := make(map[*Test]bool)
+	ran := make(map[*Test]bool)
+	for _, res := range result.Results {
+		ran[res.Test] = true
+		if !res.Passed {
+			failed[res.Test] = true
+		}
+	}
+
+	var selected []*Test
+	for _, test := range tests {
+		if test.ReadOnly && ran[test] && !failed[test] {
+			selected = append(selected, test)
+		}
+	}
+	if len(selected) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\nBenchmarking %d read-only tests, %d runs each\n", len(selected), r.options.Bench)
+
+	for _, test := range selected {
+		byName := make(map[string]*BenchResult)
+		var names []string
+		for range r.options.Bench {
+			if ctx.Err() != nil {
+				break
+			}
+			res := r.RunTest(ctx, test)
+			leaves := res.Subtests
+			if len(leaves) == 0 {
+				leaves = []*Result{res}
+			}
+			for _, leaf := range leaves {
+				b, ok := byName[leaf.Name()]
+				if !ok {
+					b = &BenchResult{Name: leaf.Name()}
+					byName[leaf.Name()] = b
+					names = append(names, leaf.Name())
+				}
+				b.Durations = append(b.Durations, leaf.Duration)
+				if !leaf.Passed {
+					b.Failures++
+				}
+			}
+		}
+		for _, name := range names {
+			b := byName[name]
+			result.Bench = append(result.Bench, b)
+			fmt.Fprintf(out, "BENCH: %s (%d runs, %d failed)\n", name, len(b.Durations), b.Failures)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Case tables. A test with cases runs its request.gql once per case.
+const (
+	CasesFile = "cases.jsonl" // One {"name", "variables", "response"} object per line
+	CasesDir  = "cases"       // One directory per case with variables.json and response.json
+)
+
+// TestCase is one row of a test's case table.
+type TestCase struct {
+	Name      string
+	Variables json.RawMessage // Layered over the test's variables.json (optional)
+	Response  json.RawMessage // Expected response; without one the case passes if the response has no errors
+}
+
+// loadCases reads the cases of the test in dir from cases.jsonl, then from
+// the cases/ directory. It returns nil if the test has neither.
+func loadCases(dir string) ([]TestCase, error) {
+	var cases []TestCase
+
+	path := filepath.Join(dir, CasesFile)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		row := bytes.TrimSpace(scanner.Bytes())
+		if len(row) == 0 {
+			continue
+		}
+		var tc struct {
+			Name      string          `json:"name"`
+			Variables json.RawMessage `json:"variables"`
+			Response  json.RawMessage `json:"response"`
+		}
+		if err := json.Unmarshal(row, &tc); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if tc.Name == "" {
+			tc.Name = fmt.Sprintf("case_%03d", line)
+		}
+		cases = append(cases, TestCase{Name: tc.Name, Variables: tc.Variables, Response: tc.Response})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, CasesDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		caseDir := filepath.Join(dir, CasesDir, entry.Name())
+		tc := TestCase{Name: entry.Name()}
+		if tc.Variables, err = readOptionalJSON(filepath.Join(caseDir, "variables.json")); err != nil {
+			return nil, err
+		}
+		if tc.Response, err = readOptionalJSON(filepath.Join(caseDir, "response.json")); err != nil {
+			return nil, err
+		}
+		cases = append(cases, tc)
+	}
+
+	seen := make(map[string]bool, len(cases))
+	for _, tc := range cases {
+		if seen[tc.Name] {
+			return nil, fmt.Errorf("duplicate case %q in %s", tc.Name, dir)
+		}
+		seen[tc.Name] = true
+	}
+	return cases, nil
+}
+
+// readOptionalJSON returns the contents of the JSON file at path, or nil if
+// it does not exist.
+func readOptionalJSON(path string) (json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%s is not valid JSON", path)
+	}
+	return data, nil
+}
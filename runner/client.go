@@ -37,8 +37,20 @@ func NewGraphQLClient(endpoint string, accountID string, headers map[string]stri
 	}
 }
 
+// SetTransport replaces the HTTP transport used for requests. Pass nil to
+// restore the default transport.
+func (c *GraphQLClient) SetTransport(rt http.RoundTripper) {
+	c.httpClient.Transport = rt
+}
+
 // Execute sends a GraphQL request and returns the raw JSON response.
 func (c *GraphQLClient) Execute(ctx context.Context, query string, variables map[string]any) ([]byte, error) {
+	return c.ExecuteWithHeaders(ctx, query, variables, nil)
+}
+
+// ExecuteWithHeaders is like Execute but also sends the given headers. They
+// override the defaults; headers passed to NewGraphQLClient still win.
+func (c *GraphQLClient) ExecuteWithHeaders(ctx context.Context, query string, variables map[string]any, headers map[string]string) ([]byte, error) {
 	reqBody := GraphQLRequest{
 		Query:     query,
 		Variables: variables,
@@ -57,6 +69,10 @@ func (c *GraphQLClient) Execute(ctx context.Context, query string, variables map
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Twisp-Account-Id", c.accountID)
 
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
 	// Apply custom headers (override defaults if same key)
 	for key, value := range c.headers {
 		req.Header.Set(key, value)
@@ -2,10 +2,16 @@ package runner
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
@@ -18,34 +24,158 @@ const (
 	GraphQLEndpoint = "/financial/v1/graphql"
 )
 
+// Labels set on containers started by the runner.
+const (
+	LabelRunner = "com.twisp.test-runner"        // Set on every container
+	LabelReuse  = "com.twisp.test-runner.reuse"  // Set on containers started with Reuse
+	LabelConfig = "com.twisp.test-runner.config" // Hash of the ContainerOptions a container was started with
+)
+
+// ContainerOptions configures StartTwispContainer.
+type ContainerOptions struct {
+	Image      string            // Fully qualified image (default TwispImage)
+	AlwaysPull bool              // Pull the image even if it is present locally
+	Env        map[string]string // Extra environment variables, e.g. feature flags
+	Files      []ContainerFile   // Host files copied into the container before it starts
+
+	Memory int64   // Memory limit in bytes (0 for no limit)
+	CPUs   float64 // CPU limit in cores (0 for no limit)
+
+	Network        string   // Docker network to join in addition to the default one
+	NetworkAliases []string // Aliases of the container on Network
+
+	StartupTimeout time.Duration // How long to wait for the container to become healthy (default 2m)
+	HealthPath     string        // HTTP path on the GraphQL port polled for readiness (default /healthcheck)
+
+	// LogDir is the directory the container's logs are streamed to (default
+	// the directory for temporary files).
+	LogDir string
+
+	// Reuse attaches to a running container started by an earlier run with
+	// the same options, or starts one that later runs can attach to. Reused
+	// containers survive Terminate and are removed by StopReusedContainers.
+	// The testcontainers reaper must be disabled (TESTCONTAINERS_RYUK_DISABLED=true)
+	// before the first container is started, or it removes the container
+	// when the process exits.
+	Reuse bool
+	// ReuseSlot tells apart several reused containers with the same options,
+	// e.g. one per worker.
+	ReuseSlot int
+}
+
+// ContainerFile is a host file copied into the container.
+type ContainerFile struct {
+	HostPath      string
+	ContainerPath string
+}
+
+// configHash identifies the container configuration for reuse matching.
+// Files count by their content, so editing a seed file starts a new
+// container. Settings that only affect how the runner waits are left out.
+func (o ContainerOptions) configHash() string {
+	type hashedFile struct {
+		ContainerFile
+		Content string // sha256 of the host file, or the error reading it
+	}
+	files := make([]hashedFile, len(o.Files))
+	for i, f := range o.Files {
+		files[i].ContainerFile = f
+		if data, err := os.ReadFile(f.HostPath); err != nil {
+			files[i].Content = err.Error()
+		} else {
+			sum := sha256.Sum256(data)
+			files[i].Content = hex.EncodeToString(sum[:])
+		}
+	}
+	data, _ := json.Marshal(struct {
+		Image          string
+		Env            map[string]string
+		Files          []hashedFile
+		Memory         int64
+		CPUs           float64
+		Network        string
+		NetworkAliases []string
+	}{o.Image, o.Env, files, o.Memory, o.CPUs, o.Network, o.NetworkAliases})
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])[:12]
+}
+
 // TwispContainer wraps a testcontainer running the Twisp local image.
 type TwispContainer struct {
 	Container  testcontainers.Container
 	GraphQLURL string
 	AdminURL   string
 	GRPCPort   int
+	GRPCAddr   string      // host:port of the gRPC server
+	Reused     bool        // Started with ContainerOptions.Reuse; left running by Terminate
+	Logs       *LogCapture // Everything the container wrote since the runner attached
 }
 
 // StartTwispContainer starts a new Twisp container and waits for it to be ready.
-func StartTwispContainer(ctx context.Context, image string, alwaysPull bool) (*TwispContainer, error) {
-	if strings.TrimSpace(image) == "" {
-		image = TwispImage
+func StartTwispContainer(ctx context.Context, opts ContainerOptions) (*TwispContainer, error) {
+	if strings.TrimSpace(opts.Image) == "" {
+		opts.Image = TwispImage
 	}
 
+	if opts.StartupTimeout <= 0 {
+		opts.StartupTimeout = 2 * time.Minute
+	}
+	if opts.HealthPath == "" {
+		opts.HealthPath = "/healthcheck"
+	}
+
+	logs, err := NewLogCapture(opts.LogDir)
+	if err != nil {
+		return nil, err
+	}
+	configHash := opts.configHash()
 	req := testcontainers.ContainerRequest{
-		Image:           image,
-		AlwaysPullImage: alwaysPull,
+		Image:           opts.Image,
+		AlwaysPullImage: opts.AlwaysPull,
 		ExposedPorts:    []string{AdminPort + "/tcp", HTTPPort + "/tcp", GRPCPort + "/tcp"},
+		Env:             opts.Env,
+		Labels: map[string]string{
+			LabelRunner: "true",
+			LabelConfig: configHash,
+		},
 		WaitingFor: wait.ForAll(
-			wait.ForHTTP("/healthcheck").WithPort(HTTPPort).WithStartupTimeout(2 * time.Minute),
+			wait.ForHTTP(opts.HealthPath).WithPort(HTTPPort).WithStartupTimeout(opts.StartupTimeout),
 		),
+		LogConsumerCfg: &testcontainers.LogConsumerConfig{
+			Consumers: []testcontainers.LogConsumer{logs},
+		},
+	}
+	for _, f := range opts.Files {
+		req.Files = append(req.Files, testcontainers.ContainerFile{
+			HostFilePath:      f.HostPath,
+			ContainerFilePath: f.ContainerPath,
+			FileMode:          0o644,
+		})
+	}
+	if opts.Memory > 0 || opts.CPUs > 0 {
+		req.HostConfigModifier = func(hc *dockercontainer.HostConfig) {
+			hc.Memory = opts.Memory
+			hc.NanoCPUs = int64(opts.CPUs * 1e9)
+		}
+	}
+	if opts.Network != "" {
+		req.Networks = []string{opts.Network}
+		if len(opts.NetworkAliases) > 0 {
+			req.NetworkAliases = map[string][]string{opts.Network: opts.NetworkAliases}
+		}
+	}
+	if opts.Reuse {
+		req.Name = fmt.Sprintf("twisp-test-runner-%s-%d", configHash, opts.ReuseSlot)
+		req.Labels[LabelReuse] = "true"
 	}
 
 	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
 		ContainerRequest: req,
 		Started:          true,
+		Reuse:            opts.Reuse,
 	})
 	if err != nil {
+		logs.Close()
 		return nil, fmt.Errorf("failed to start container: %w", err)
 	}
 
@@ -78,13 +208,48 @@ func StartTwispContainer(ctx context.Context, image string, alwaysPull bool) (*T
 		GraphQLURL: fmt.Sprintf("http://%s:%s%s", host, httpPort.Port(), GraphQLEndpoint),
 		AdminURL:   fmt.Sprintf("http://%s:%s", host, adminPort.Port()),
 		GRPCPort:   grpcPort.Int(),
+		GRPCAddr:   fmt.Sprintf("%s:%s", host, grpcPort.Port()),
+		Reused:     opts.Reuse,
+		Logs:       logs,
 	}, nil
 }
 
-// Terminate stops and removes the container.
+// Terminate stops and removes the container. Reused containers are left
+// running for the next run.
 func (c *TwispContainer) Terminate(ctx context.Context) error {
-	if c.Container != nil {
+	if c.Container != nil && !c.Reused {
 		return c.Container.Terminate(ctx)
 	}
 	return nil
 }
+
+// StopReusedContainers removes every container left running by runs with
+// ContainerOptions.Reuse and returns their names.
+func StopReusedContainers(ctx context.Context) ([]string, error) {
+	cli, err := testcontainers.NewDockerClientWithOpts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	list, err := cli.ContainerList(ctx, dockercontainer.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelReuse+"=true")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var stopped []string
+	for _, c := range list {
+		name := c.ID[:12]
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		if err := cli.ContainerRemove(ctx, c.ID, dockercontainer.RemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+			return stopped, fmt.Errorf("failed to remove container %s: %w", name, err)
+		}
+		stopped = append(stopped, name)
+	}
+	return stopped, nil
+}
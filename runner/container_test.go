@@ -0,0 +1,29 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigHashCoversFileContent(t *testing.T) {
+	seed := filepath.Join(t.TempDir(), "seed.sql")
+	if err := os.WriteFile(seed, []byte("insert 1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := ContainerOptions{
+		Image: TwispImage,
+		Files: []ContainerFile{{HostPath: seed, ContainerPath: "/seed.sql"}},
+	}
+
+	before := opts.configHash()
+	if again := opts.configHash(); again != before {
+		t.Fatalf("hash changed without an edit: %s, then %s", before, again)
+	}
+	if err := os.WriteFile(seed, []byte("insert 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if after := opts.configHash(); after == before {
+		t.Errorf("hash %s did not change with the file's content", after)
+	}
+}
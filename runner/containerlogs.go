@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// LogLine is one line of container output.
+type LogLine struct {
+	Time   time.Time // When the runner received the line
+	Stream string    // "STDOUT" or "STDERR"
+	Text   string
+}
+
+// LogCapture is a testcontainers log consumer that streams everything a
+// container writes to a file, stamped with the time it arrived, so the logs
+// can be written out after a failed run or sliced to the window of a single
+// test without holding them in memory. Lines already received survive the
+// runner being killed. It is safe for concurrent use.
+type LogCapture struct {
+	mu     sync.Mutex
+	file   *os.File
+	closed bool
+	err    error // First error writing the file
+}
+
+// NewLogCapture returns a capture streaming to a new file in dir, or in the
+// default directory for temporary files if dir is empty.
+func NewLogCapture(dir string) (*LogCapture, error) {
+	f, err := os.CreateTemp(dir, "twisp-container-*.log")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container log file: %w", err)
+	}
+	return &LogCapture{file: f}, nil
+}
+
+// Path returns the file the logs are streamed to.
+func (c *LogCapture) Path() string {
+	return c.file.Name()
+}
+
+// Accept implements testcontainers.LogConsumer.
+func (c *LogCapture) Accept(l testcontainers.Log) {
+	now := time.Now()
+	text := strings.TrimRight(string(l.Content), "\n")
+	if text == "" {
+		return
+	}
+
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(&b, "%s\t%s\t%s\n", now.Format(time.RFC3339Nano), l.LogType, strings.TrimRight(line, "\r"))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil && !c.closed {
+		_, c.err = io.WriteString(c.file, b.String())
+	}
+}
+
+// Close stops streaming to the file, which is kept. Lines received later
+// are dropped.
+func (c *LogCapture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.file.Close()
+}
+
+// Each calls fn with every captured line, in the order received.
+func (c *LogCapture) Each(fn func(LogLine) error) error {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to write container log file: %w", err)
+	}
+
+	f, err := os.Open(c.Path())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		raw, err := r.ReadString('\n')
+		if err == io.EOF {
+			// A partial last line is still being written
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stamp, rest, _ := strings.Cut(strings.TrimSuffix(raw, "\n"), "\t")
+		stream, text, _ := strings.Cut(rest, "\t")
+		t, err := time.Parse(time.RFC3339Nano, stamp)
+		if err != nil {
+			return fmt.Errorf("malformed line in container log file %s: %w", c.Path(), err)
+		}
+		if err := fn(LogLine{Time: t, Stream: stream, Text: text}); err != nil {
+			return err
+		}
+	}
+}
+
+// Between returns the lines received from start to end, inclusive.
+func (c *LogCapture) Between(start, end time.Time) ([]LogLine, error) {
+	var lines []LogLine
+	err := c.Each(func(line LogLine) error {
+		if !line.Time.Before(start) && !line.Time.After(end) {
+			lines = append(lines, line)
+		}
+		return nil
+	})
+	return lines, err
+}
+
+// WriteLogLine writes line to w, prefixed with its timestamp and stream.
+func WriteLogLine(w io.Writer, line LogLine) error {
+	_, err := fmt.Fprintf(w, "%s %s %s\n", line.Time.Format("15:04:05.000"), line.Stream, line.Text)
+	return err
+}
+
+// WriteLogLines writes lines to w, one per line, prefixed with their
+// timestamp and stream.
+func WriteLogLines(w io.Writer, lines []LogLine) error {
+	for _, line := range lines {
+		if err := WriteLogLine(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
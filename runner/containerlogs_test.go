@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestLogCaptureStreamsToFile(t *testing.T) {
+	c, err := NewLogCapture(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Accept(testcontainers.Log{LogType: "STDOUT", Content: []byte("starting\r\nlistening on :8080\n")})
+	start := time.Now()
+	c.Accept(testcontainers.Log{LogType: "STDERR", Content: []byte("panic:\tboom\n")})
+	end := time.Now()
+	time.Sleep(time.Millisecond)
+	c.Accept(testcontainers.Log{LogType: "STDOUT", Content: []byte("\n")}) // Blank output is dropped
+	c.Accept(testcontainers.Log{LogType: "STDOUT", Content: []byte("recovered")})
+
+	// The lines are on disk before anyone asks for them
+	data, err := os.ReadFile(c.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		t.Fatal("nothing was written to the log file")
+	}
+
+	var all []string
+	err = c.Each(func(line LogLine) error {
+		all = append(all, line.Stream+" "+line.Text)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"STDOUT starting", "STDOUT listening on :8080", "STDERR panic:\tboom", "STDOUT recovered"}
+	if !slices.Equal(all, want) {
+		t.Errorf("lines = %q, want %q", all, want)
+	}
+
+	between, err := c.Between(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(between) != 1 || between[0].Text != "panic:\tboom" {
+		t.Errorf("Between = %+v, want only the panic line", between)
+	}
+
+	// Lines after Close are dropped, and the file can still be read
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.Accept(testcontainers.Log{LogType: "STDOUT", Content: []byte("late")})
+	count := 0
+	if err := c.Each(func(LogLine) error { count++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if count != len(want) {
+		t.Errorf("%d lines after Close, want %d", count, len(want))
+	}
+}
@@ -9,24 +9,53 @@ import (
 	"strings"
 )
 
+// RequestType says which API a test's request is sent to.
+type RequestType string
+
+const (
+	RequestGraphQL RequestType = "graphql" // request.gql
+	RequestAdmin   RequestType = "admin"   // request.admin.json
+	RequestGRPC    RequestType = "grpc"    // request.grpc.json
+)
+
 // Test represents a single test case with its associated files.
 type Test struct {
-	Name      string // Test name (directory name)
-	Dir       string // Relative directory path
-	AbsDir    string // Absolute directory path
-	Seq       int    // Sequence number for ordering (-1 if not sequenced)
-	Request   string // Path to request.gql
-	Response  string // Path to response.json
-	Variables string // Path to variables.json (optional)
-	Transform string // Path to transform.jq (optional)
+	Name            string       // Test name (directory name)
+	Dir             string       // Relative directory path
+	AbsDir          string       // Absolute directory path
+	Seq             int          // Sequence number for ordering (-1 if not sequenced)
+	Request         string       // Path to request.gql, request.admin.json or request.grpc.json
+	RequestType     RequestType  // API the request is sent to
+	Response        string       // Path to response.json
+	Variables       string       // Path to variables.json (optional)
+	Transform       []string     // Paths of jq transforms to apply, outermost directory first
+	ActualTransform string       // Path to transform.actual.jq, applied to the actual response only (optional)
+	Needs           []string     // Dirs of tests in the same suite this test depends on (from suite.yaml)
+	Cases           []TestCase   // Rows of cases.jsonl and cases/, each run as a subtest (optional)
+	ReadOnly        bool         // Changes no state, so it may be benchmarked (from suite.yaml)
+	Config          *SuiteConfig // Effective suite.yaml settings for the test's directory
+	Setup           []*Test      // Hook steps of the test's directory when it is not the suite root
+	Teardown        []*Test      // Run around the test itself; see Suite for the root's
 }
 
+// Hook directories and files. Setup steps run before a suite's tests and
+// teardown steps after them.
+const (
+	SetupDir    = "setup"
+	TeardownDir = "teardown"
+	BeforeFile  = "before.gql"
+	AfterFile   = "after.gql"
+)
+
 // Suite represents a test suite with a base test and child tests.
 type Suite struct {
 	Path     string            // Relative path of the suite
 	Base     *Test             // Base test for this suite (may be nil)
 	Tests    map[string]string // Map of test name to child suite path
 	Children map[string]*Suite // Child suites
+	Config   *SuiteConfig      // Effective suite.yaml settings
+	Setup    []*Test           // before.gql, then the steps in setup/
+	Teardown []*Test           // The steps in teardown/, then after.gql
 	refs     int               // Reference count (internal)
 }
 
@@ -34,13 +63,28 @@ type Suite struct {
 type Suites map[string]*Suite
 
 // DiscoverTests walks the given directory and discovers all test fixtures.
+// suite.yaml and transform.jq files apply from the directory down.
 func DiscoverTests(suitePath string) (Suites, error) {
+	return DiscoverTestsUnder(suitePath, suitePath)
+}
+
+// DiscoverTestsUnder is DiscoverTests for a suite inside root, such as the
+// --test_suite_path it was found under. suite.yaml and transform.jq files
+// in root and the directories between root and the suite apply too.
+func DiscoverTestsUnder(root, suitePath string) (Suites, error) {
 	absPath, err := filepath.Abs(suitePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get absolute path: %w", err)
 	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
 
 	suites := make(Suites)
+	configs := make(map[string]*SuiteConfig)
+	needs := make(map[string][]string)
+	readOnly := make(map[string]bool)
 
 	err = filepath.Walk(absPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -57,12 +101,62 @@ func DiscoverTests(suitePath string) (Suites, error) {
 			relPath = ""
 		}
 
+		// Case tables are read with their test below. Next to no request,
+		// cases is an ordinary directory, such as a suite's.
+		if info.IsDir() && info.Name() == CasesDir && relPath != "" && hasRequestFile(filepath.Dir(path)) {
+			return filepath.SkipDir
+		}
+
+		if info.IsDir() && (info.Name() == SetupDir || info.Name() == TeardownDir) && relPath != "" {
+			suite, ok := suites[getParentPath(relPath)]
+			if !ok {
+				return filepath.SkipDir
+			}
+			steps, err := discoverHookSteps(absRoot, path, relPath)
+			if err != nil {
+				return err
+			}
+			if info.Name() == SetupDir {
+				suite.Setup = append(suite.Setup, steps...)
+			} else {
+				suite.Teardown = append(steps, suite.Teardown...)
+			}
+			return filepath.SkipDir
+		}
+
 		if info.IsDir() {
-			suites[relPath] = &Suite{
+			// Directories are visited before their contents, so the parent's
+			// effective config is always known here.
+			var cfg *SuiteConfig
+			if relPath == "" {
+				cfg, err = resolveSuiteConfig(absRoot, path)
+			} else {
+				var own *SuiteConfig
+				own, err = readSuiteConfig(path)
+				cfg = own.inherit(configs[getParentPath(relPath)])
+				if own != nil {
+					needs[relPath] = own.needs
+					readOnly[relPath] = own.readOnly
+				}
+			}
+			if err != nil {
+				return err
+			}
+			configs[relPath] = cfg
+
+			suite := &Suite{
 				Path:     relPath,
 				Tests:    make(map[string]string),
 				Children: make(map[string]*Suite),
+				Config:   cfg,
+			}
+			if before := hookFileStep(path, relPath, BeforeFile, cfg); before != nil {
+				suite.Setup = append(suite.Setup, before)
 			}
+			if after := hookFileStep(path, relPath, AfterFile, cfg); after != nil {
+				suite.Teardown = append(suite.Teardown, after)
+			}
+			suites[relPath] = suite
 			return nil
 		}
 
@@ -70,6 +164,9 @@ func DiscoverTests(suitePath string) (Suites, error) {
 		if !isTest {
 			return nil
 		}
+		test.Config = configs[test.Dir]
+		test.Needs = needs[test.Dir]
+		test.ReadOnly = readOnly[test.Dir]
 
 		suite, ok := suites[test.Dir]
 		if !ok {
@@ -77,6 +174,10 @@ func DiscoverTests(suitePath string) (Suites, error) {
 		}
 
 		isNew := suite.Base == nil
+		if !isNew && suite.Base.Request != "" && test.Request != "" {
+			return fmt.Errorf("'%s' has more than one request file (%s and %s)", suite.Path,
+				filepath.Base(suite.Base.Request), filepath.Base(test.Request))
+		}
 		if isNew {
 			suite.Base = test
 		} else {
@@ -112,16 +213,127 @@ func DiscoverTests(suitePath string) (Suites, error) {
 	if err != nil {
 		return nil, err
 	}
+	suites.groupUnsequenced()
 
 	for path, suite := range suites {
+		hooks := len(suite.Setup) > 0 || len(suite.Teardown) > 0
 		if suite.Base == nil && len(suite.Tests) == 0 {
+			if hooks && path != "" {
+				return nil, fmt.Errorf("'%s' has setup or teardown steps, but no test or sequenced tests of its own to run them around", path)
+			}
 			delete(suites, path)
+			continue
+		}
+		// Below the root, a test directory's hooks run around its test
+		if suite.Base != nil && path != "" {
+			suite.Base.Setup, suite.Base.Teardown = suite.Setup, suite.Teardown
+		}
+		if suite.Base != nil {
+			suite.Base.Transform = suite.Base.Config.Transforms
+			if suite.Base.Request != "" {
+				if suite.Base.Cases, err = loadCases(suite.Base.AbsDir); err != nil {
+					return nil, err
+				}
+			}
+			if len(suite.Base.Cases) > 0 && suite.Base.Response != "" {
+				return nil, fmt.Errorf("'%s' has both response.json and cases (%s or %s/); give each case its own response instead", suite.Path, CasesFile, CasesDir)
+			}
 		}
 	}
 
 	return suites, nil
 }
 
+// groupUnsequenced makes the unsequenced tests under a test directory
+// whose suite.yaml sets group_unsequenced part of that directory's suite, to
+// run in its tenant after its sequenced tests. Otherwise each is a suite of
+// its own, in its own tenant, as are those under a plain grouping directory.
+func (s Suites) groupUnsequenced() {
+	for path, suite := range s {
+		if path == "" || suite.Base == nil || suite.Base.Seq >= 0 {
+			continue
+		}
+		parent, ok := s[getParentPath(path)]
+		if !ok || parent.Base == nil || parent.Config == nil || !parent.Config.Grouped {
+			continue
+		}
+		if _, ok := parent.Tests[suite.Base.Name]; ok {
+			continue
+		}
+		parent.Tests[suite.Base.Name] = path
+		parent.Children[suite.Base.Name] = suite
+		suite.refs++
+	}
+}
+
+// discoverHookSteps returns the steps of a setup/ or teardown/ directory at
+// relDir. The directory is laid out like a suite: a test of its own and/or
+// sequenced child tests, run in order. Its steps inherit the suite's config
+// from root down.
+func discoverHookSteps(root, absDir, relDir string) ([]*Test, error) {
+	suites, err := DiscoverTestsUnder(root, absDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover %s: %w", relDir, err)
+	}
+	steps := suites.GetOrderedTests("")
+	for _, step := range steps {
+		step.Dir = filepath.Join(relDir, step.Dir)
+	}
+	return steps, nil
+}
+
+// hookFileStep returns the step for a before.gql or after.gql file in dir,
+// or nil if there is none. Variables come from before.variables.json and
+// the expected response from before.json; without one, the step passes if
+// the response has no errors.
+func hookFileStep(absDir, relDir, file string, cfg *SuiteConfig) *Test {
+	request := filepath.Join(absDir, file)
+	if info, err := os.Stat(request); err != nil || info.IsDir() {
+		return nil
+	}
+
+	name := strings.TrimSuffix(file, ".gql")
+	step := &Test{
+		Name:        name,
+		Dir:         filepath.Join(relDir, file),
+		AbsDir:      absDir,
+		Seq:         -1,
+		Request:     request,
+		RequestType: RequestGraphQL,
+		Transform:   cfg.Transforms,
+		Config:      cfg,
+	}
+	if _, err := os.Stat(filepath.Join(absDir, name+".json")); err == nil {
+		step.Response = filepath.Join(absDir, name+".json")
+	}
+	if _, err := os.Stat(filepath.Join(absDir, name+".variables.json")); err == nil {
+		step.Variables = filepath.Join(absDir, name+".variables.json")
+	}
+	return step
+}
+
+// NonGraphQLRequests returns the request files of the admin API and gRPC
+// tests and hook steps of the suites, sorted.
+func (s Suites) NonGraphQLRequests() []string {
+	var requests []string
+	add := func(test *Test) {
+		if test != nil && test.Request != "" && test.RequestType != RequestGraphQL {
+			requests = append(requests, test.Request)
+		}
+	}
+	for _, suite := range s {
+		add(suite.Base)
+		for _, step := range suite.Setup {
+			add(step)
+		}
+		for _, step := range suite.Teardown {
+			add(step)
+		}
+	}
+	sort.Strings(requests)
+	return requests
+}
+
 // GetOrderedTests returns all tests from the suite in execution order.
 func (s Suites) GetOrderedTests(suitePath string) []*Test {
 	var tests []*Test
@@ -212,6 +424,16 @@ func (s Suites) runWithVisited(fn func(*Test), suitePath string, maxSeq int, vis
 	}
 }
 
+// hasRequestFile reports whether dir holds a request file of any kind.
+func hasRequestFile(dir string) bool {
+	for _, name := range []string{"request.gql", AdminRequestFile, GRPCRequestFile} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
 // parseTestFile extracts test information from a file path.
 func parseTestFile(basePath, fullPath string, info os.FileInfo) (*Test, bool) {
 	if info.IsDir() {
@@ -265,12 +487,19 @@ func parseTestFile(basePath, fullPath string, info os.FileInfo) (*Test, bool) {
 	switch fileName {
 	case "request.gql":
 		test.Request = fullPath
+		test.RequestType = RequestGraphQL
+	case AdminRequestFile:
+		test.Request = fullPath
+		test.RequestType = RequestAdmin
+	case GRPCRequestFile:
+		test.Request = fullPath
+		test.RequestType = RequestGRPC
 	case "response.json":
 		test.Response = fullPath
 	case "variables.json":
 		test.Variables = fullPath
-	case "transform.jq":
-		test.Transform = fullPath
+	case "transform.actual.jq":
+		test.ActualTransform = fullPath
 	default:
 		return nil, false
 	}
@@ -282,6 +511,7 @@ func parseTestFile(basePath, fullPath string, info os.FileInfo) (*Test, bool) {
 func mergeTest(dst, src *Test) *Test {
 	if src.Request != "" {
 		dst.Request = src.Request
+		dst.RequestType = src.RequestType
 	}
 	if src.Response != "" {
 		dst.Response = src.Response
@@ -289,8 +519,8 @@ func mergeTest(dst, src *Test) *Test {
 	if src.Variables != "" {
 		dst.Variables = src.Variables
 	}
-	if src.Transform != "" {
-		dst.Transform = src.Transform
+	if src.ActualTransform != "" {
+		dst.ActualTransform = src.ActualTransform
 	}
 	return dst
 }
@@ -308,6 +538,8 @@ func getParentPath(path string) string {
 }
 
 // IsValid returns true if the test has both request and response files.
+// The request may be of any RequestType. A case table stands in for the
+// response file.
 func (t *Test) IsValid() bool {
-	return t.Request != "" && t.Response != ""
+	return t.Request != "" && (t.Response != "" || len(t.Cases) > 0)
 }
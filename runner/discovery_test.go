@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTransformChainStopsAtRoot(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"transform.jq":                        ".",
+		"fixtures/transform.jq":               ".",
+		"fixtures/ledger/transform.jq":        ".",
+		"fixtures/ledger/request.gql":         "query { a }",
+		"fixtures/ledger/response.json":       "{}",
+		"fixtures/ledger/001_A/transform.jq":  ".",
+		"fixtures/ledger/001_A/request.gql":   "query { b }",
+		"fixtures/ledger/001_A/response.json": "{}",
+	})
+	root := filepath.Join(dir, "fixtures")
+	ledger := filepath.Join(root, "ledger")
+
+	tests := []struct {
+		name  string
+		root  string
+		suite string
+		want  map[string][]string // Test dir -> transforms, relative to dir
+	}{
+		{
+			name:  "suite under the root",
+			root:  root,
+			suite: ledger,
+			want: map[string][]string{
+				"":      {"fixtures/transform.jq", "fixtures/ledger/transform.jq"},
+				"001_A": {"fixtures/transform.jq", "fixtures/ledger/transform.jq", "fixtures/ledger/001_A/transform.jq"},
+			},
+		},
+		{
+			name:  "suite as its own root",
+			root:  ledger,
+			suite: ledger,
+			want: map[string][]string{
+				"":      {"fixtures/ledger/transform.jq"},
+				"001_A": {"fixtures/ledger/transform.jq", "fixtures/ledger/001_A/transform.jq"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suites, err := DiscoverTestsUnder(tt.root, tt.suite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := suites.GetOrderedTests("")
+			if len(found) != len(tt.want) {
+				t.Fatalf("found %d tests, want %d", len(found), len(tt.want))
+			}
+			for _, test := range found {
+				var got []string
+				for _, path := range test.Transform {
+					rel, err := filepath.Rel(dir, path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, filepath.ToSlash(rel))
+				}
+				if want := tt.want[test.Dir]; !slices.Equal(got, want) {
+					t.Errorf("transforms of %q = %v, want %v", test.Dir, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseWithCasesIsRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{
+			name:  "cases.jsonl",
+			files: map[string]string{"001_A/" + CasesFile: `{"name": "one", "response": {"data": {}}}`},
+		},
+		{
+			name:  "cases directory",
+			files: map[string]string{"001_A/" + CasesDir + "/one/response.json": `{"data": {}}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, merge(queryFixture("001_A/", "a"), tt.files))
+			_, err := DiscoverTests(dir)
+			if err == nil || !strings.Contains(err.Error(), "'001_A' has both response.json and cases") {
+				t.Errorf("error = %v, want one about 001_A's response.json and cases", err)
+			}
+		})
+	}
+}
+
+func TestCasesDirectoryWithoutRequestIsASuite(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, merge(
+		queryFixture("g/"+CasesDir+"/001_X/", "x"),
+		queryFixture("g/other/", "other"),
+	))
+	suites, err := DiscoverTests(filepath.Join(dir, "g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for _, suitePath := range suites.RunnableSuitePaths() {
+		for _, test := range suites.GetOrderedTests(suitePath) {
+			dirs = append(dirs, test.Dir)
+		}
+	}
+	sort.Strings(dirs)
+	if want := []string{CasesDir + "/001_X", "other"}; !slices.Equal(dirs, want) {
+		t.Errorf("tests %q, want %q", dirs, want)
+	}
+}
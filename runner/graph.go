@@ -0,0 +1,212 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// testGraph orders the tests of a suite in which some tests declare needs.
+// A test with needs runs after those tests and is skipped if any of them
+// fails or is skipped. Other tests keep their place in the linear order:
+// each runs after the test before it, and unsequenced tests run after the
+// last sequenced one, but without skipping on failure.
+type testGraph struct {
+	tests      []*Test
+	after      [][]int // Tests that must finish first
+	needs      [][]int // The subset of after that must also pass
+	dependents [][]int // Reverse of after
+}
+
+// hasNeeds reports whether any test declares needs.
+func hasNeeds(tests []*Test) bool {
+	for _, test := range tests {
+		if len(test.Needs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestGraph builds the graph for tests, as ordered by GetOrderedTests.
+func newTestGraph(suites Suites, tests []*Test) (*testGraph, error) {
+	g := &testGraph{
+		tests:      tests,
+		after:      make([][]int, len(tests)),
+		needs:      make([][]int, len(tests)),
+		dependents: make([][]int, len(tests)),
+	}
+
+	byName := make(map[string]int, len(tests))
+	for i, test := range tests {
+		byName[test.Name] = i
+	}
+
+	root := -1
+	if len(tests) > 0 && suites[""] != nil && tests[0] == suites[""].Base {
+		root = 0
+	}
+	serial, _ := splitUnsequenced(suites, tests)
+	lastSerial := len(serial) - 1
+
+	for i, test := range tests {
+		switch {
+		case i == root:
+		case len(test.Needs) > 0:
+			if root >= 0 {
+				g.after[i] = append(g.after[i], root)
+				g.needs[i] = append(g.needs[i], root)
+			}
+			for _, name := range test.Needs {
+				j, ok := byName[name]
+				if !ok || j == root {
+					return nil, fmt.Errorf("test %q needs %q, which is not a test in this suite", test.Dir, name)
+				}
+				if j == i {
+					return nil, fmt.Errorf("test %q needs itself", test.Dir)
+				}
+				g.after[i] = append(g.after[i], j)
+				g.needs[i] = append(g.needs[i], j)
+			}
+		case i > lastSerial && lastSerial >= 0:
+			g.after[i] = []int{lastSerial}
+		case i > 0:
+			g.after[i] = []int{i - 1}
+		}
+		for _, j := range g.after[i] {
+			g.dependents[j] = append(g.dependents[j], i)
+		}
+	}
+
+	if cycle := g.cycle(); cycle != nil {
+		names := make([]string, len(cycle))
+		for k, i := range cycle {
+			names[k] = tests[i].Name
+		}
+		return nil, fmt.Errorf("test dependencies form a cycle: %s", strings.Join(names, " -> "))
+	}
+	return g, nil
+}
+
+// cycle returns the tests of a dependency cycle, or nil if there is none.
+func (g *testGraph) cycle() []int {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(g.tests))
+	var stack []int
+	var visit func(i int) []int
+	visit = func(i int) []int {
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, j := range g.after[i] {
+			switch state[j] {
+			case visiting:
+				for k, s := range stack {
+					if s == j {
+						return append(append([]int(nil), stack[k:]...), j)
+					}
+				}
+			case unvisited:
+				if c := visit(j); c != nil {
+					return c
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = done
+		return nil
+	}
+	for i := range g.tests {
+		if state[i] == unvisited {
+			if c := visit(i); c != nil {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// runGraph runs the tests of g, up to Options.ParallelTests at once,
+// retrying failed tests as plan says, and records their results in test
+// order. Tests whose needs failed are reported as skipped. With FailFast, no
+// further tests are started once one fails.
+func (r *Runner) runGraph(ctx context.Context, out io.Writer, result *SuiteResult, g *testGraph, plan *retryPlan) {
+	n := len(g.tests)
+	results := make([]*Result, n)
+	skippedBy := make([]int, n) // Index+1 of the failed test a test was skipped for
+	waiting := make([]int, n)
+	for i := range g.tests {
+		waiting[i] = len(g.after[i])
+	}
+
+	type outcome struct {
+		i      int
+		result *Result
+	}
+	done := make(chan outcome)
+	var ready []int
+	for i := range g.tests {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	// finish releases the dependents of test i, skipping those that need it
+	// if it did not pass
+	var finish func(i int, passed bool, cause int)
+	finish = func(i int, passed bool, cause int) {
+		for _, j := range g.dependents[i] {
+			if skippedBy[j] > 0 {
+				continue
+			}
+			if !passed && slices.Contains(g.needs[j], i) {
+				skippedBy[j] = cause + 1
+				finish(j, false, cause)
+				continue
+			}
+			waiting[j]--
+			if waiting[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+
+	limit := max(r.options.ParallelTests, 1)
+	running := 0
+	stopped := false
+	for {
+		for !stopped && running < limit && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				done <- outcome{i, r.runTestWithRetries(ctx, plan, g.tests[i])}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+		o := <-done
+		running--
+		results[o.i] = o.result
+		if !o.result.Passed && r.options.FailFast {
+			stopped = true
+		}
+		finish(o.i, o.result.Passed, o.i)
+	}
+
+	for i, test := range g.tests {
+		switch {
+		case results[i] != nil:
+			r.record(out, result, results[i])
+		case skippedBy[i] > 0:
+			result.Skipped++
+			fmt.Fprintf(out, "SKIP: %s (needs %s, which did not pass)\n", test.Dir, g.tests[skippedBy[i]-1].Dir)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTestGraphErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		needs   map[string]string // Test dir -> needs list in suite.yaml
+		wantErr string
+	}{
+		{
+			name:    "cycle",
+			needs:   map[string]string{"001_A": "[003_C]", "002_B": "[001_A]", "003_C": "[002_B]"},
+			wantErr: "test dependencies form a cycle: ",
+		},
+		{
+			name:    "unknown test",
+			needs:   map[string]string{"002_B": "[001_A, 009_Missing]"},
+			wantErr: `test "002_B" needs "009_Missing", which is not a test in this suite`,
+		},
+		{
+			name:    "itself",
+			needs:   map[string]string{"002_B": "[002_B]"},
+			wantErr: `test "002_B" needs itself`,
+		},
+		{
+			name:  "valid",
+			needs: map[string]string{"002_B": "[001_A]", "003_C": "[001_A]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := merge(queryFixture("", "root"), queryFixture("001_A/", "a"), queryFixture("002_B/", "b"), queryFixture("003_C/", "c"))
+			for test, needs := range tt.needs {
+				files[test+"/suite.yaml"] = "needs: " + needs + "\n"
+			}
+			writeFiles(t, dir, files)
+
+			suites, err := DiscoverTests(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = newTestGraph(suites, suites.GetOrderedTests(""))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTestGraphSkipsDependentsOfFailures(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, merge(
+		queryFixture("", "root"),
+		queryFixture("001_A/", "a"),
+		queryFixture("002_B/", "b"),
+		queryFixture("003_C/", "c"),
+		queryFixture("004_D/", "d"),
+		map[string]string{
+			"002_B/suite.yaml": "needs: [001_A]\n",
+			"003_C/suite.yaml": "needs: [002_B]\n", // Skipped through 002_B
+			// 004_D has no needs, so it runs after 003_C without needing it
+		},
+	))
+
+	fake := &fakeGraphQL{failing: map[string]bool{"query { a }": true}}
+	result := runSuite(t, fake, dir, Options{ParallelTests: 2})
+
+	if result.Passed != 2 || result.Failed != 1 || result.Skipped != 2 {
+		t.Errorf("passed %d, failed %d, skipped %d; want 2, 1 and 2", result.Passed, result.Failed, result.Skipped)
+	}
+	for _, q := range fake.queries {
+		if q == "query { b }" || q == "query { c }" {
+			t.Errorf("%s ran, but it needs a failed test", q)
+		}
+	}
+	if !slices.Contains(fake.queries, "query { d }") {
+		t.Error("004_D did not run")
+	}
+}
@@ -0,0 +1,344 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// GRPCRequestFile is the name of a test step that makes a unary gRPC call.
+const GRPCRequestFile = "request.grpc.json"
+
+// GRPCRequest is the content of request.grpc.json.
+type GRPCRequest struct {
+	Method   string            `json:"method"`   // Full method name, e.g. twisp.core.v1.LedgerService/GetBalance
+	Message  json.RawMessage   `json:"message"`  // Request message in protobuf JSON form
+	Metadata map[string]string `json:"metadata"` // Extra request metadata
+}
+
+// GRPCClient makes unary gRPC calls described in JSON. Message types are
+// resolved through server reflection, or from a descriptor set file
+// (protoc --descriptor_set_out --include_imports) when one is given.
+type GRPCClient struct {
+	addr      string
+	accountID string
+	headers   map[string]string
+
+	mu      sync.Mutex
+	conn    *grpc.ClientConn
+	methods map[string]resolvedMethod // Resolved via reflection
+}
+
+// resolvedMethod is a method descriptor and the registry it came from, which
+// resolves google.protobuf.Any contents in its messages.
+type resolvedMethod struct {
+	desc  protoreflect.MethodDescriptor
+	files *protoregistry.Files
+}
+
+// NewGRPCClient creates a client for the gRPC server at addr (host:port).
+// Calls carry the tenant's account ID and the given headers as metadata.
+func NewGRPCClient(addr string, accountID string, headers map[string]string) *GRPCClient {
+	return &GRPCClient{
+		addr:      addr,
+		accountID: accountID,
+		headers:   headers,
+		methods:   make(map[string]resolvedMethod),
+	}
+}
+
+// LoadGRPCRequest reads and validates a request.grpc.json file.
+func LoadGRPCRequest(path string) (*GRPCRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var req GRPCRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	req.Method = strings.TrimPrefix(req.Method, "/")
+	if _, _, ok := splitGRPCMethod(req.Method); !ok {
+		return nil, fmt.Errorf("%s: method must look like package.Service/Method, got %q", path, req.Method)
+	}
+	return &req, nil
+}
+
+// Invoke makes the call and returns the response message as protobuf JSON.
+// A call that fails with a gRPC status is not an error: the status is
+// returned as {"error": {"code": ..., "message": ...}} so tests can assert
+// on it. Only Unavailable, which means the server could not be reached, and
+// context errors are returned as errors. Metadata from headers overrides
+// the defaults; headers passed to NewGRPCClient still win.
+func (c *GRPCClient) Invoke(ctx context.Context, grpcReq *GRPCRequest, headers map[string]string, protoset string) ([]byte, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := c.resolveMethod(ctx, conn, grpcReq.Method, protoset)
+	if err != nil {
+		return nil, err
+	}
+	method := resolved.desc
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return nil, fmt.Errorf("method %s is streaming; only unary calls are supported", grpcReq.Method)
+	}
+
+	resolver := dynamicpb.NewTypes(resolved.files)
+	in := dynamicpb.NewMessage(method.Input())
+	if len(grpcReq.Message) > 0 {
+		if err := (protojson.UnmarshalOptions{Resolver: resolver}).Unmarshal(grpcReq.Message, in); err != nil {
+			return nil, fmt.Errorf("invalid %s message: %w", method.Input().FullName(), err)
+		}
+	}
+
+	md := metadata.Pairs("x-twisp-account-id", c.accountID)
+	for _, h := range []map[string]string{headers, grpcReq.Metadata, c.headers} {
+		for key, value := range h {
+			md.Set(strings.ToLower(key), value)
+		}
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	out := dynamicpb.NewMessage(method.Output())
+	if err := conn.Invoke(ctx, "/"+grpcReq.Method, in, out); err != nil {
+		st, ok := status.FromError(err)
+		if !ok || st.Code() == codes.Unavailable || ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to call %s: %w", grpcReq.Method, err)
+		}
+		return json.Marshal(map[string]any{
+			"error": map[string]string{
+				"code":    st.Code().String(),
+				"message": st.Message(),
+			},
+		})
+	}
+
+	return protojson.MarshalOptions{Resolver: resolver}.Marshal(out)
+}
+
+// Close closes the connection, if one was opened.
+func (c *GRPCClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *GRPCClient) connect() (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		conn, err := grpc.NewClient(c.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to gRPC server %s: %w", c.addr, err)
+		}
+		c.conn = conn
+	}
+	return c.conn, nil
+}
+
+// resolveMethod finds the descriptor of fullMethod in the descriptor set
+// file protoset, or through server reflection if protoset is empty.
+func (c *GRPCClient) resolveMethod(ctx context.Context, conn *grpc.ClientConn, fullMethod, protoset string) (resolvedMethod, error) {
+	if protoset != "" {
+		files, err := loadProtoset(protoset)
+		if err != nil {
+			return resolvedMethod{}, err
+		}
+		desc, err := findMethod(files, fullMethod)
+		return resolvedMethod{desc: desc, files: files}, err
+	}
+
+	c.mu.Lock()
+	method, ok := c.methods[fullMethod]
+	c.mu.Unlock()
+	if ok {
+		return method, nil
+	}
+
+	service, _, _ := splitGRPCMethod(fullMethod)
+	files, err := reflectFiles(ctx, conn, service)
+	if err != nil {
+		return resolvedMethod{}, fmt.Errorf("failed to resolve %s via server reflection: %w", fullMethod, err)
+	}
+	desc, err := findMethod(files, fullMethod)
+	if err != nil {
+		return resolvedMethod{}, err
+	}
+	method = resolvedMethod{desc: desc, files: files}
+
+	c.mu.Lock()
+	c.methods[fullMethod] = method
+	c.mu.Unlock()
+	return method, nil
+}
+
+// protosetCache holds parsed descriptor set files by path.
+var protosetCache = struct {
+	sync.Mutex
+	files map[string]*protoregistry.Files
+}{files: make(map[string]*protoregistry.Files)}
+
+func loadProtoset(path string) (*protoregistry.Files, error) {
+	protosetCache.Lock()
+	defer protosetCache.Unlock()
+	if files, ok := protosetCache.files[path]; ok {
+		return files, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set %s: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s (was it built with --include_imports?): %w", path, err)
+	}
+	protosetCache.files[path] = files
+	return files, nil
+}
+
+// reflectFiles fetches the file defining service and all of its
+// dependencies from the server's reflection service.
+func reflectFiles(ctx context.Context, conn *grpc.ClientConn, service string) (*protoregistry.Files, error) {
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	fetched := make(map[string]*descriptorpb.FileDescriptorProto)
+	var order []*descriptorpb.FileDescriptorProto
+	request := func(req *reflectionpb.ServerReflectionRequest) error {
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if errResp := resp.GetErrorResponse(); errResp != nil {
+			return errors.New(errResp.GetErrorMessage())
+		}
+		for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			var fd descriptorpb.FileDescriptorProto
+			if err := proto.Unmarshal(raw, &fd); err != nil {
+				return err
+			}
+			if _, ok := fetched[fd.GetName()]; !ok {
+				fetched[fd.GetName()] = &fd
+				order = append(order, &fd)
+			}
+		}
+		return nil
+	}
+
+	if err := request(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+	}); err != nil {
+		return nil, err
+	}
+	// Servers may omit dependencies they already sent or consider
+	// well-known, so ask for any that are still missing
+	for i := 0; i < len(order); i++ {
+		for _, dep := range order[i].GetDependency() {
+			if _, ok := fetched[dep]; ok {
+				continue
+			}
+			if _, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+				continue
+			}
+			if err := request(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+			}); err != nil {
+				return nil, fmt.Errorf("fetching %s: %w", dep, err)
+			}
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	if _, err := stream.Recv(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return newFilesWithGlobals(order)
+}
+
+// newFilesWithGlobals builds a registry from fds, falling back to the
+// well-known types linked into this binary for missing dependencies.
+func newFilesWithGlobals(fds []*descriptorpb.FileDescriptorProto) (*protoregistry.Files, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	have := make(map[string]bool)
+	for _, fd := range fds {
+		have[fd.GetName()] = true
+		set.File = append(set.File, fd)
+	}
+	for _, fd := range fds {
+		for _, dep := range fd.GetDependency() {
+			if have[dep] {
+				continue
+			}
+			global, err := protoregistry.GlobalFiles.FindFileByPath(dep)
+			if err != nil {
+				return nil, fmt.Errorf("missing dependency %s", dep)
+			}
+			have[dep] = true
+			set.File = append(set.File, protodesc.ToFileDescriptorProto(global))
+		}
+	}
+	return protodesc.NewFiles(set)
+}
+
+// findMethod looks up package.Service/Method in files.
+func findMethod(files *protoregistry.Files, fullMethod string) (protoreflect.MethodDescriptor, error) {
+	service, name, _ := splitGRPCMethod(fullMethod)
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not found: %w", service, err)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	method := sd.Methods().ByName(protoreflect.Name(name))
+	if method == nil {
+		return nil, fmt.Errorf("method %s not found in service %s", name, service)
+	}
+	return method, nil
+}
+
+// splitGRPCMethod splits "package.Service/Method".
+func splitGRPCMethod(fullMethod string) (service, method string, ok bool) {
+	service, method, ok = strings.Cut(fullMethod, "/")
+	return service, method, ok && service != "" && method != "" && !strings.Contains(method, "/")
+}
@@ -0,0 +1,297 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Exchange is a single recorded GraphQL request/response pair.
+type Exchange struct {
+	AccountID string          `json:"accountId"`
+	Query     string          `json:"query"`
+	Variables json.RawMessage `json:"variables,omitempty"`
+	Response  json.RawMessage `json:"response"`
+}
+
+// Recording is the on-disk format written by Recorder and served by ReplayServer.
+type Recording struct {
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// LoadRecording reads a recording file written by Recorder.Save.
+func LoadRecording(path string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to parse recording %q: %w", path, err)
+	}
+	return &rec, nil
+}
+
+// Recorder is an http.RoundTripper that captures successful GraphQL
+// exchanges passing through it. It is safe for concurrent use.
+type Recorder struct {
+	base      http.RoundTripper
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// NewRecorder creates a Recorder that forwards requests to base.
+// A nil base uses http.DefaultTransport.
+func NewRecorder(base http.RoundTripper) *Recorder {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Recorder{base: base}
+}
+
+// RoundTrip forwards the request and records it if the server answered 200.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := r.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	var gqlReq GraphQLRequest
+	if err := json.Unmarshal(reqBody, &gqlReq); err != nil {
+		return resp, nil
+	}
+	var vars json.RawMessage
+	if len(gqlReq.Variables) > 0 {
+		vars, _ = json.Marshal(gqlReq.Variables)
+	}
+
+	r.mu.Lock()
+	r.exchanges = append(r.exchanges, Exchange{
+		AccountID: req.Header.Get("X-Twisp-Account-Id"),
+		Query:     gqlReq.Query,
+		Variables: vars,
+		Response:  json.RawMessage(respBody),
+	})
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// Save writes all recorded exchanges to path.
+func (r *Recorder) Save(path string) error {
+	r.mu.Lock()
+	rec := Recording{Exchanges: r.exchanges}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal recording: %w", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// ReplayServer is an in-process HTTP stand-in for a Twisp GraphQL endpoint
+// that answers from a Recording. Requests are matched on normalized query,
+// variables and tenant. Repeated identical requests are answered with the
+// recorded responses in order; once exhausted, the last one is repeated.
+type ReplayServer struct {
+	GraphQLURL string
+
+	server    *http.Server
+	listener  net.Listener
+	mu        sync.Mutex
+	responses map[string][]json.RawMessage
+	served    map[string]int
+}
+
+// StartReplayServer loads the recording at path and serves it on a random
+// local port.
+func StartReplayServer(path string) (*ReplayServer, error) {
+	rec, err := LoadRecording(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ReplayServer{
+		responses: make(map[string][]json.RawMessage),
+		served:    make(map[string]int),
+	}
+	for _, ex := range rec.Exchanges {
+		key, err := replayKey(ex.AccountID, ex.Query, ex.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exchange in recording %q: %w", path, err)
+		}
+		s.responses[key] = append(s.responses[key], ex.Response)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	s.listener = listener
+	s.server = &http.Server{Handler: s}
+	s.GraphQLURL = fmt.Sprintf("http://%s%s", listener.Addr().String(), GraphQLEndpoint)
+
+	go s.server.Serve(listener)
+
+	return s, nil
+}
+
+// ServeHTTP answers a GraphQL request from the recording.
+func (s *ReplayServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var gqlReq struct {
+		Query     string          `json:"query"`
+		Variables json.RawMessage `json:"variables"`
+	}
+	if err := json.Unmarshal(body, &gqlReq); err != nil {
+		http.Error(w, fmt.Sprintf("invalid GraphQL request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	accountID := req.Header.Get("X-Twisp-Account-Id")
+	key, err := replayKey(accountID, gqlReq.Query, gqlReq.Variables)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	responses := s.responses[key]
+	n := s.served[key]
+	s.served[key] = n + 1
+	s.mu.Unlock()
+
+	if len(responses) == 0 {
+		http.Error(w, fmt.Sprintf("no recorded response for account %q and query %s",
+			accountID, truncate(normalizeQuery(gqlReq.Query), 80)), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responses[min(n, len(responses)-1)])
+}
+
+// Close shuts down the server.
+func (s *ReplayServer) Close() error {
+	return s.server.Close()
+}
+
+// replayKey builds the lookup key for an exchange.
+func replayKey(accountID, query string, variables json.RawMessage) (string, error) {
+	vars := "null"
+	if len(variables) > 0 {
+		var v any
+		if err := json.Unmarshal(variables, &v); err != nil {
+			return "", fmt.Errorf("failed to parse variables: %w", err)
+		}
+		// An empty object is what omitempty drops, so treat it like none.
+		if m, ok := v.(map[string]any); !ok || len(m) > 0 {
+			// Re-marshal to normalize key order and whitespace
+			norm, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			vars = string(norm)
+		}
+	}
+	return accountID + "\x00" + normalizeQuery(query) + "\x00" + vars, nil
+}
+
+// normalizeQuery drops comments and collapses whitespace runs in a GraphQL
+// document so formatting changes to request.gql don't invalidate a
+// recording. String literals are kept as they are.
+func normalizeQuery(query string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(query); {
+		var end int
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+			continue
+		case c == '#':
+			for i < len(query) && query[i] != '\n' && query[i] != '\r' {
+				i++
+			}
+			space = true
+			continue
+		case strings.HasPrefix(query[i:], `"""`):
+			end = blockStringEnd(query, i+3)
+		case c == '"':
+			end = stringEnd(query, i+1)
+		default:
+			end = i + 1
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(query[i:end])
+		i = end
+	}
+	return b.String()
+}
+
+// stringEnd returns the index just past the string literal whose content
+// starts at i.
+func stringEnd(query string, i int) int {
+	for i < len(query) {
+		switch query[i] {
+		case '\\':
+			i += 2
+		case '"':
+			return i + 1
+		case '\n', '\r':
+			return i // Unterminated
+		default:
+			i++
+		}
+	}
+	return len(query)
+}
+
+// blockStringEnd returns the index just past the block string whose content
+// starts at i.
+func blockStringEnd(query string, i int) int {
+	for i < len(query) {
+		switch {
+		case strings.HasPrefix(query[i:], `\"""`):
+			i += 4
+		case strings.HasPrefix(query[i:], `"""`):
+			return i + 3
+		default:
+			i++
+		}
+	}
+	return len(query)
+}
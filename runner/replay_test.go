@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "collapses whitespace",
+			query: "query {\n\taccount(id: $id)  {\r\n  name }\n}\n",
+			want:  "query { account(id: $id) { name } }",
+		},
+		{
+			name:  "drops comments",
+			query: "# Look up the account\nquery {\n  account { name } # display name\n}",
+			want:  "query { account { name } }",
+		},
+		{
+			name:  "keeps strings",
+			query: `query { account(code: "a  # b") { name } }`,
+			want:  `query { account(code: "a  # b") { name } }`,
+		},
+		{
+			name:  "keeps escaped quotes in strings",
+			query: `query { account(code: "a \"  # b\"") { name } }`,
+			want:  `query { account(code: "a \"  # b\"") { name } }`,
+		},
+		{
+			name:  "keeps block strings",
+			query: "mutation {\n  note(text: \"\"\"\n  two  lines # kept\n  \\\"\"\" \"\"\")\n}",
+			want:  "mutation { note(text: \"\"\"\n  two  lines # kept\n  \\\"\"\" \"\"\") }",
+		},
+		{
+			name:  "empty",
+			query: " \n# only a comment\n",
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeQuery(tt.query); got != tt.want {
+				t.Errorf("normalizeQuery(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayKey(t *testing.T) {
+	const query = "query { account(id: $id) { name } }"
+	key := func(t *testing.T, accountID, query, variables string) string {
+		t.Helper()
+		var vars json.RawMessage
+		if variables != "" {
+			vars = json.RawMessage(variables)
+		}
+		k, err := replayKey(accountID, query, vars)
+		if err != nil {
+			t.Fatalf("replayKey: %v", err)
+		}
+		return k
+	}
+
+	tests := []struct {
+		name      string
+		accountID string
+		query     string
+		variables string
+		same      bool
+	}{
+		{
+			name:      "reformatted query",
+			accountID: "tenant-a",
+			query:     "# Look up the account\nquery {\n  account(id: $id) {\n    name\n  }\n}\n",
+			variables: `{"id": "1", "at": "now"}`,
+			same:      true,
+		},
+		{
+			name:      "variable key order",
+			accountID: "tenant-a",
+			query:     query,
+			variables: `{ "at":"now",  "id":"1" }`,
+			same:      true,
+		},
+		{
+			name:      "different variables",
+			accountID: "tenant-a",
+			query:     query,
+			variables: `{"id": "2", "at": "now"}`,
+		},
+		{
+			name:      "different tenant",
+			accountID: "tenant-b",
+			query:     query,
+			variables: `{"id": "1", "at": "now"}`,
+		},
+		{
+			name:      "different query",
+			accountID: "tenant-a",
+			query:     "query { account(id: $id) { code } }",
+			variables: `{"id": "1", "at": "now"}`,
+		},
+	}
+	base := key(t, "tenant-a", query, `{"id": "1", "at": "now"}`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := key(t, tt.accountID, tt.query, tt.variables) == base; got != tt.same {
+				t.Errorf("same key = %v, want %v", got, tt.same)
+			}
+		})
+	}
+
+	t.Run("empty variables match none", func(t *testing.T) {
+		if key(t, "tenant-a", query, "{}") != key(t, "tenant-a", query, "") {
+			t.Error("{} and no variables have different keys")
+		}
+	})
+	t.Run("invalid variables", func(t *testing.T) {
+		if _, err := replayKey("tenant-a", query, json.RawMessage(`{"id":`)); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
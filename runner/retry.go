@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// retryPlan says what a failed test is retried on top of. Each retry runs in
+// a fresh tenant, so the suite's setup and the tests the failed one builds
+// on are replayed first, and the suite's teardown runs afterwards.
+type retryPlan struct {
+	setup    []*Test
+	teardown []*Test
+	prereqs  map[*Test][]*Test
+}
+
+// newRetryPlan returns the plan for retrying tests, as ordered by
+// GetOrderedTests.
+func newRetryPlan(suites Suites, tests, setup, teardown []*Test, graph *testGraph) *retryPlan {
+	return &retryPlan{
+		setup:    setup,
+		teardown: teardown,
+		prereqs:  prerequisites(suites, tests, graph),
+	}
+}
+
+// prerequisites returns the tests each test builds on, as ordered by
+// GetOrderedTests. Without a graph, a sequenced test builds on the tests
+// before it, and an unsequenced test on all sequenced ones. With a graph, a
+// test builds on everything it waits for, directly or not.
+func prerequisites(suites Suites, tests []*Test, graph *testGraph) map[*Test][]*Test {
+	prereqs := make(map[*Test][]*Test, len(tests))
+
+	if graph == nil {
+		serial, _ := splitUnsequenced(suites, tests)
+		for i, test := range tests {
+			prereqs[test] = validTests(serial[:min(i, len(serial))])
+		}
+		return prereqs
+	}
+
+	for i, test := range tests {
+		seen := make([]bool, len(tests))
+		var mark func(j int)
+		mark = func(j int) {
+			for _, k := range graph.after[j] {
+				if !seen[k] {
+					seen[k] = true
+					mark(k)
+				}
+			}
+		}
+		mark(i)
+		var before []*Test
+		for j, ok := range seen {
+			if ok {
+				before = append(before, tests[j])
+			}
+		}
+		prereqs[test] = validTests(before)
+	}
+	return prereqs
+}
+
+// validTests returns the tests that can run.
+func validTests(tests []*Test) []*Test {
+	var valid []*Test
+	for _, test := range tests {
+		if test.IsValid() {
+			valid = append(valid, test)
+		}
+	}
+	return valid
+}
+
+// runTestWithRetries runs test and, if it fails and plan is set, retries it
+// up to Options.Retries times, each in a fresh tenant. A test that passes on
+// a retry is reported as passed and flaky.
+func (r *Runner) runTestWithRetries(ctx context.Context, plan *retryPlan, test *Test) *Result {
+	result := r.RunTest(ctx, test)
+	if result.Passed || plan == nil {
+		return result
+	}
+
+	attempts := 1
+	for attempt := 1; attempt <= r.options.Retries && ctx.Err() == nil; attempt++ {
+		attempts++
+		retry := r.retryTest(ctx, plan, test, attempt)
+		if retry.Passed {
+			retry.markFlaky(attempts)
+			return retry
+		}
+	}
+	result.Attempts = attempts
+	for _, sub := range result.Subtests {
+		sub.Attempts = attempts
+	}
+	return result
+}
+
+// retryTest replays the prerequisites of test in a fresh tenant, then runs
+// it there.
+func (r *Runner) retryTest(ctx context.Context, plan *retryPlan, test *Test, attempt int) *Result {
+	tenant := r.forTenant(retryAccountID(r.accountID, test, attempt))
+	defer tenant.Close()
+
+	// Like the suite's own teardown, this always runs
+	defer func() {
+		teardownCtx := context.WithoutCancel(ctx)
+		for _, step := range plan.teardown {
+			tenant.RunTest(teardownCtx, step)
+		}
+	}()
+
+	start := time.Now()
+	steps := append(append([]*Test(nil), plan.setup...), plan.prereqs[test]...)
+	for _, step := range steps {
+		if res := tenant.RunTest(ctx, step); !res.Passed {
+			return &Result{
+				Test:     test,
+				Started:  start,
+				Duration: time.Since(start),
+				Error:    fmt.Errorf("retry %d: prerequisite %s failed: %w", attempt, step.Dir, res.Error),
+			}
+		}
+	}
+	return tenant.RunTest(ctx, test)
+}
+
+// markFlaky records that the result passed on the given attempt, after
+// failing before.
+func (r *Result) markFlaky(attempts int) {
+	r.Flaky = true
+	r.Attempts = attempts
+	for _, sub := range r.Subtests {
+		sub.markFlaky(attempts)
+	}
+}
+
+// forTenant returns a runner like r that sends its requests as the tenant
+// accountID.
+func (r *Runner) forTenant(accountID string) *Runner {
+	tenant := NewRunner(r.client.endpoint, r.options, accountID, r.headers)
+	tenant.client.httpClient.Transport = r.client.httpClient.Transport
+	tenant.client.httpClient.Timeout = r.client.httpClient.Timeout
+	tenant.output = r.output
+
+	var adminURL, grpcAddr string
+	if r.admin != nil {
+		adminURL = r.admin.baseURL
+	}
+	if r.grpc != nil {
+		grpcAddr = r.grpc.addr
+	}
+	tenant.SetServiceEndpoints(adminURL, grpcAddr)
+	return tenant
+}
+
+// retryAccountID returns the tenant for one retry of test. It is derived
+// from the suite's tenant, so it is new for each attempt and test, yet the
+// same on every run, which lets --replay answer retries from a recording.
+func retryAccountID(accountID string, test *Test, attempt int) string {
+	key := fmt.Sprintf("%s\x00%s\x00%d", accountID, test.Dir, attempt)
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
@@ -4,21 +4,39 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Result represents the outcome of a single test.
 type Result struct {
 	Test     *Test
+	Case     string // Name of the case for a subtest of a test with cases
 	Passed   bool
+	Started  time.Time
 	Duration time.Duration
 	Error    error
 	Expected string
 	Actual   string
+	Subtests []*Result // Per-case results of a test with cases
+	Attempts int       // Times the test ran, counting retries (0 if it was not retried)
+	Flaky    bool      // Failed, then passed on a retry
+}
+
+// Name returns the test's directory, followed by /case for a case.
+func (r *Result) Name() string {
+	if r.Case != "" {
+		return path.Join(r.Test.Dir, r.Case)
+	}
+	return r.Test.Dir
 }
 
 // SuiteResult represents the outcome of running a test suite.
@@ -28,30 +46,60 @@ type SuiteResult struct {
 	Passed    int
 	Failed    int
 	Skipped   int
+	Flaky     int // Passed tests that needed a retry
 	Duration  time.Duration
+	Bench     []*BenchResult // Latencies of read-only tests with Options.Bench
 }
 
 // Options configures the test runner behavior.
 type Options struct {
-	Verbose  bool // Print detailed output
-	FailFast bool // Stop on first failure
+	Verbose  bool          // Print detailed output
+	FailFast bool          // Stop on first failure
+	Timeout  time.Duration // Per-request timeout (0 for the client default)
+
+	// JQLibrary is a jq module directory searched after any jq_library
+	// directories declared in suite.yaml files.
+	JQLibrary string
+
+	// ParallelTests is how many unsequenced tests of a suite may run at
+	// once, after its sequenced tests. Values below 2 run them one by one.
+	ParallelTests int
+
+	// Retries is how many times a failed test is retried, each time in a
+	// fresh tenant after replaying the suite's setup and the tests it
+	// builds on.
+	Retries int
+
+	// Bench is how many more times each read-only test that passed is run,
+	// after the suite's tests, to measure its latency. 0 disables it.
+	Bench int
 }
 
 // Runner executes GraphQL tests against a Twisp endpoint.
 type Runner struct {
 	client    *GraphQLClient
+	admin     *AdminClient // nil until SetServiceEndpoints
+	grpc      *GRPCClient  // nil until SetServiceEndpoints
 	options   Options
 	accountID string
+	headers   map[string]string
 	output    io.Writer
+	selected  []string // Dirs of the tests RunSuite runs; nil for all
+	root      string   // Directory suite config is inherited from; "" for the suite's own
 }
 
 // NewRunner creates a new test runner for the given GraphQL endpoint.
 // Custom headers will be applied to all requests (overriding defaults if same key).
 func NewRunner(endpoint string, options Options, accountID string, headers map[string]string) *Runner {
+	client := NewGraphQLClient(endpoint, accountID, headers)
+	if options.Timeout > 0 {
+		client.httpClient.Timeout = options.Timeout
+	}
 	return &Runner{
-		client:    NewGraphQLClient(endpoint, accountID, headers),
+		client:    client,
 		options:   options,
 		accountID: accountID,
+		headers:   headers,
 		output:    os.Stdout,
 	}
 }
@@ -65,11 +113,62 @@ func (r *Runner) SetOutput(w io.Writer) {
 	r.output = w
 }
 
+// SetTransport sets the HTTP transport used for GraphQL requests, e.g. a
+// Recorder capturing traffic for later replay.
+func (r *Runner) SetTransport(rt http.RoundTripper) {
+	r.client.SetTransport(rt)
+}
+
+// SetServiceEndpoints enables admin API and gRPC test steps against the
+// admin API at adminURL and the gRPC server at grpcAddr (host:port). Either
+// may be empty, in which case steps of that type fail.
+func (r *Runner) SetServiceEndpoints(adminURL, grpcAddr string) {
+	r.admin, r.grpc = nil, nil
+	if adminURL != "" {
+		r.admin = NewAdminClient(adminURL, r.accountID, r.headers)
+		if r.options.Timeout > 0 {
+			r.admin.httpClient.Timeout = r.options.Timeout
+		}
+	}
+	if grpcAddr != "" {
+		r.grpc = NewGRPCClient(grpcAddr, r.accountID, r.headers)
+	}
+}
+
+// SelectTests limits RunSuite to the tests in the given directories,
+// relative to the suite, and the tests they build on. Setup and teardown
+// still run. If a directory is not a test of the suite, such as a setup
+// step, the whole suite runs, and RunSuite says so. Pass nil to run every
+// test.
+func (r *Runner) SelectTests(dirs []string) {
+	r.selected = dirs
+}
+
+// SetSuiteRoot makes RunSuite apply the suite.yaml and transform.jq files
+// of root and the directories between root and the suite, such as the
+// --test_suite_path the suite was found under. Pass "" to apply only those
+// in the suite's directory and below.
+func (r *Runner) SetSuiteRoot(root string) {
+	r.root = root
+}
+
+// Close releases connections held by the runner.
+func (r *Runner) Close() error {
+	if r.grpc != nil {
+		return r.grpc.Close()
+	}
+	return nil
+}
+
 // RunSuite executes all tests in the given suite path.
 func (r *Runner) RunSuite(ctx context.Context, suitePath string) (*SuiteResult, error) {
 	start := time.Now()
 
-	suites, err := DiscoverTests(suitePath)
+	root := r.root
+	if root == "" {
+		root = suitePath
+	}
+	suites, err := DiscoverTestsUnder(root, suitePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to discover tests: %w", err)
 	}
@@ -86,91 +185,386 @@ func (r *Runner) RunSuite(ctx context.Context, suitePath string) (*SuiteResult,
 		out = os.Stdout
 	}
 
-	fmt.Fprintf(out, "\n=== Running suite: %s ===\n", suitePath)
-	fmt.Fprintf(out, "Discovered %d tests\n\n", len(tests))
+	var setup, teardown []*Test
+	if root, ok := suites[""]; ok {
+		setup, teardown = root.Setup, root.Teardown
+	}
 
-	for _, test := range tests {
-		if !test.IsValid() {
-			result.Skipped++
-			if r.options.Verbose {
-				fmt.Fprintf(out, "SKIP: %s (missing request.gql or response.json)\n", test.Dir)
+	// Tests that declare needs run as a dependency graph instead of in order
+	var graph *testGraph
+	if hasNeeds(tests) {
+		if graph, err = newTestGraph(suites, tests); err != nil {
+			return nil, err
+		}
+	}
+
+	// A selection, such as the tests that failed last time, runs with the
+	// tests it builds on
+	discovered := len(tests)
+	var selectErr error
+	if r.selected != nil {
+		var selected []*Test
+		if selected, selectErr = selectTests(suites, tests, graph, r.selected); selectErr == nil {
+			tests = selected
+			if graph != nil {
+				if graph, err = newTestGraph(suites, tests); err != nil {
+					return nil, err
+				}
 			}
-			continue
 		}
+	}
 
-		testResult := r.RunTest(ctx, test)
-		result.Results = append(result.Results, testResult)
+	var plan *retryPlan
+	if r.options.Retries > 0 {
+		plan = newRetryPlan(suites, tests, setup, teardown, graph)
+	}
 
-		if testResult.Passed {
-			result.Passed++
-			fmt.Fprintf(out, "PASS: %s (%v)\n", test.Dir, testResult.Duration.Round(time.Millisecond))
-		} else {
-			result.Failed++
-			fmt.Fprintf(out, "FAIL: %s (%v)\n", test.Dir, testResult.Duration.Round(time.Millisecond))
-			if testResult.Error != nil {
-				fmt.Fprintf(out, "      Error: %v\n", testResult.Error)
-			}
-			if r.options.Verbose && testResult.Expected != "" && testResult.Actual != "" {
-				fmt.Fprintf(out, "      Expected: %s\n", compact(testResult.Expected))
-				fmt.Fprintf(out, "      Actual:   %s\n", compact(testResult.Actual))
+	fmt.Fprintf(out, "\n=== Running suite: %s ===\n", suitePath)
+	if selectErr != nil {
+		fmt.Fprintf(out, "Running all tests instead of the selected ones: %v\n", selectErr)
+	}
+	if len(tests) < discovered {
+		fmt.Fprintf(out, "Discovered %d tests, running %d: the selected ones and the tests they build on\n\n", discovered, len(tests))
+	} else {
+		fmt.Fprintf(out, "Discovered %d tests\n\n", len(tests))
+	}
+
+	// Setup must pass before any test runs
+	setupPassed := true
+	for _, step := range setup {
+		if !r.runStep(ctx, out, result, step) {
+			setupPassed = false
+			break
+		}
+	}
+
+	if setupPassed && graph != nil {
+		r.runGraph(ctx, out, result, graph, plan)
+	} else if setupPassed {
+		// Unsequenced tests sort last and depend only on what ran before
+		// them, so they may run concurrently
+		serial, concurrent := tests, []*Test(nil)
+		if r.options.ParallelTests > 1 {
+			serial, concurrent = splitUnsequenced(suites, tests)
+		}
+
+		failed := false
+		for _, test := range serial {
+			if !test.IsValid() {
+				result.Skipped++
+				if r.options.Verbose {
+					fmt.Fprintf(out, "SKIP: %s (missing request.gql or response.json)\n", test.Dir)
+				}
+				continue
 			}
 
-			if r.options.FailFast {
+			if !r.record(out, result, r.runTestWithRetries(ctx, plan, test)) && r.options.FailFast {
+				failed = true
 				break
 			}
 		}
+		if !failed && len(concurrent) > 0 {
+			r.runConcurrent(ctx, out, result, concurrent, plan)
+		}
+	} else {
+		result.Skipped += len(tests)
+		fmt.Fprintf(out, "SKIP: %d tests (setup failed)\n", len(tests))
+	}
+
+	if setupPassed && r.options.Bench > 0 {
+		r.bench(ctx, out, result, tests)
+	}
+
+	// Teardown always runs, even after failures or cancellation, so a
+	// shared tenant is not left half-populated. Every step is attempted.
+	teardownCtx := context.WithoutCancel(ctx)
+	for _, step := range teardown {
+		r.runStep(teardownCtx, out, result, step)
 	}
 
 	result.Duration = time.Since(start)
 
-	fmt.Fprintf(out, "\n=== Suite complete: %d passed, %d failed, %d skipped (%v) ===\n",
-		result.Passed, result.Failed, result.Skipped, result.Duration.Round(time.Millisecond))
+	var flaky string
+	if result.Flaky > 0 {
+		flaky = fmt.Sprintf(", %d flaky", result.Flaky)
+	}
+	fmt.Fprintf(out, "\n=== Suite complete: %d passed, %d failed, %d skipped%s (%v) ===\n",
+		result.Passed, result.Failed, result.Skipped, flaky, result.Duration.Round(time.Millisecond))
 
 	return result, nil
 }
 
+// runStep runs a test or hook step, adds its result to result, prints it and
+// reports whether it passed.
+func (r *Runner) runStep(ctx context.Context, out io.Writer, result *SuiteResult, test *Test) bool {
+	return r.record(out, result, r.RunTest(ctx, test))
+}
+
+// runConcurrent runs tests on up to Options.ParallelTests workers, retrying
+// failed tests as plan says, and records their results in test order. With
+// FailFast, no further tests are started once one fails.
+func (r *Runner) runConcurrent(ctx context.Context, out io.Writer, result *SuiteResult, tests []*Test, plan *retryPlan) {
+	results := make([]*Result, len(tests))
+	next := make(chan int)
+	var stopped atomic.Bool
+	var wg sync.WaitGroup
+	for range min(r.options.ParallelTests, len(tests)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = r.runTestWithRetries(ctx, plan, tests[i])
+				if !results[i].Passed && r.options.FailFast {
+					stopped.Store(true)
+				}
+			}
+		}()
+	}
+	for i, test := range tests {
+		if stopped.Load() {
+			break
+		}
+		if !test.IsValid() {
+			continue
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i, test := range tests {
+		switch {
+		case results[i] != nil:
+			r.record(out, result, results[i])
+		case !test.IsValid():
+			result.Skipped++
+			if r.options.Verbose {
+				fmt.Fprintf(out, "SKIP: %s (missing request.gql or response.json)\n", test.Dir)
+			}
+		}
+	}
+}
+
+// splitUnsequenced splits ordered tests into the leading tests that must run
+// in order and the trailing unsequenced children of the suite root.
+func splitUnsequenced(suites Suites, tests []*Test) (serial, concurrent []*Test) {
+	var rootBase *Test
+	if root, ok := suites[""]; ok {
+		rootBase = root.Base
+	}
+	i := len(tests)
+	for i > 0 && tests[i-1].Seq < 0 && tests[i-1] != rootBase {
+		i--
+	}
+	return tests[:i], tests[i:]
+}
+
+// selectTests returns the tests in dirs and the tests they build on, in
+// order. It fails if a dir is not one of the tests.
+func selectTests(suites Suites, tests []*Test, graph *testGraph, dirs []string) ([]*Test, error) {
+	byDir := make(map[string]*Test, len(tests))
+	for _, test := range tests {
+		byDir[test.Dir] = test
+	}
+
+	prereqs := prerequisites(suites, tests, graph)
+	keep := make(map[*Test]bool)
+	for _, dir := range dirs {
+		test, ok := byDir[dir]
+		if !ok {
+			return nil, fmt.Errorf("%s is not a test of the suite", dir)
+		}
+		keep[test] = true
+		for _, prereq := range prereqs[test] {
+			keep[prereq] = true
+		}
+	}
+
+	var selected []*Test
+	for _, test := range tests {
+		if keep[test] {
+			selected = append(selected, test)
+		}
+	}
+	return selected, nil
+}
+
+// record adds testResult to result, prints it and reports whether it passed.
+// The cases of a test with cases are recorded as tests of their own. A
+// failure outside its cases, such as a failing teardown step, is recorded
+// for the test itself.
+func (r *Runner) record(out io.Writer, result *SuiteResult, testResult *Result) bool {
+	if len(testResult.Subtests) > 0 {
+		casesPassed := true
+		for _, sub := range testResult.Subtests {
+			casesPassed = r.record(out, result, sub) && casesPassed
+		}
+		if casesPassed && !testResult.Passed {
+			own := *testResult
+			own.Subtests = nil
+			r.record(out, result, &own)
+		}
+		return testResult.Passed
+	}
+
+	result.Results = append(result.Results, testResult)
+
+	if testResult.Passed {
+		result.Passed++
+		if testResult.Flaky {
+			result.Flaky++
+			fmt.Fprintf(out, "PASS: %s (%v, flaky: passed on attempt %d)\n", testResult.Name(), testResult.Duration.Round(time.Millisecond), testResult.Attempts)
+			return true
+		}
+		fmt.Fprintf(out, "PASS: %s (%v)\n", testResult.Name(), testResult.Duration.Round(time.Millisecond))
+		return true
+	}
+
+	result.Failed++
+	fmt.Fprintf(out, "FAIL: %s (%v)\n", testResult.Name(), testResult.Duration.Round(time.Millisecond))
+	if testResult.Error != nil {
+		fmt.Fprintf(out, "      Error: %v\n", testResult.Error)
+	}
+	if testResult.Attempts > 1 {
+		fmt.Fprintf(out, "      Failed all %d attempts\n", testResult.Attempts)
+	}
+	if r.options.Verbose && testResult.Expected != "" && testResult.Actual != "" {
+		fmt.Fprintf(out, "      Expected: %s\n", compact(testResult.Expected))
+		fmt.Fprintf(out, "      Actual:   %s\n", compact(testResult.Actual))
+	}
+	return false
+}
+
 // RunTest executes a single test and returns the result.
+// A test with cases runs once per case; the result passes if every case
+// passes and holds the per-case results in Subtests. The test's own setup
+// steps run first, in the same tenant, and its teardown steps after it,
+// even if it fails. A failing step fails the test.
 func (r *Runner) RunTest(ctx context.Context, test *Test) *Result {
+	if len(test.Setup) == 0 && len(test.Teardown) == 0 {
+		return r.runCases(ctx, test)
+	}
+
+	var result *Result
+	for _, step := range test.Setup {
+		if res := r.RunTest(ctx, step); !res.Passed {
+			result = &Result{
+				Test:     test,
+				Started:  res.Started,
+				Duration: res.Duration,
+				Error:    fmt.Errorf("setup step %s failed: %w", step.Dir, res.Error),
+			}
+			break
+		}
+	}
+	if result == nil {
+		result = r.runCases(ctx, test)
+	}
+
+	teardownCtx := context.WithoutCancel(ctx)
+	for _, step := range test.Teardown {
+		if res := r.RunTest(teardownCtx, step); !res.Passed && result.Passed {
+			result.Passed = false
+			result.Error = fmt.Errorf("teardown step %s failed: %w", step.Dir, res.Error)
+		}
+	}
+	return result
+}
+
+// runCases executes a test, once per case if it has cases.
+func (r *Runner) runCases(ctx context.Context, test *Test) *Result {
+	if len(test.Cases) == 0 {
+		return r.runTest(ctx, test, nil)
+	}
+
 	start := time.Now()
 	result := &Result{
-		Test: test,
+		Test:    test,
+		Started: start,
+		Passed:  true,
+	}
+	for i := range test.Cases {
+		sub := r.runTest(ctx, test, &test.Cases[i])
+		result.Subtests = append(result.Subtests, sub)
+		if !sub.Passed {
+			result.Passed = false
+			if result.Error == nil {
+				result.Error = fmt.Errorf("case %s: %w", sub.Case, sub.Error)
+			}
+		}
+	}
+	result.Duration = time.Since(start)
+	return result
+}
+
+// runTest executes a test, or one case of it if tc is set.
+func (r *Runner) runTest(ctx context.Context, test *Test, tc *TestCase) *Result {
+	start := time.Now()
+	result := &Result{
+		Test:    test,
+		Started: start,
+	}
+	testName := test.Name
+	if tc != nil {
+		result.Case = tc.Name
+		testName += "/" + tc.Name
+	}
+
+	cfg := test.Config
+	if cfg == nil {
+		cfg = &SuiteConfig{}
+	}
+
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	transformOpts := TransformOptions{
+		LibraryDirs: cfg.JQLibraries,
+		AccountID:   r.accountID,
+		TestName:    testName,
+	}
+	if r.options.JQLibrary != "" {
+		transformOpts.LibraryDirs = append(append([]string(nil), cfg.JQLibraries...), r.options.JQLibrary)
 	}
 
-	// Read request
-	query, err := os.ReadFile(test.Request)
+	// Execute request
+	actualJSON, err := r.execute(ctx, test, cfg, tc)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to read request: %w", err)
+		result.Error = err
 		result.Duration = time.Since(start)
 		return result
 	}
 
-	// Read variables if present
-	var variables map[string]any
-	if test.Variables != "" {
-		varsData, err := os.ReadFile(test.Variables)
-		if err != nil {
-			result.Error = fmt.Errorf("failed to read variables: %w", err)
-			result.Duration = time.Since(start)
-			return result
+	// Steps without an expected response, such as before.gql, only need to
+	// succeed
+	if (tc == nil && test.Response == "") || (tc != nil && tc.Response == nil) {
+		result.Actual = string(actualJSON)
+		result.Passed = !hasErrors(actualJSON)
+		result.Duration = time.Since(start)
+		if !result.Passed {
+			result.Error = fmt.Errorf("response has errors: %s", truncate(compact(result.Actual), 200))
 		}
-		if err := json.Unmarshal(varsData, &variables); err != nil {
-			result.Error = fmt.Errorf("failed to parse variables: %w", err)
+		checkBudget(result, cfg)
+		return result
+	}
+
+	// Project the actual response into the shape of response.json first, so
+	// the shared transforms below see the same structure on both sides
+	if test.ActualTransform != "" {
+		actualJSON, err = TransformJSON([]string{test.ActualTransform}, actualJSON, transformOpts)
+		if err != nil {
+			result.Error = fmt.Errorf("failed to apply actual-only transform: %w", err)
 			result.Duration = time.Since(start)
 			return result
 		}
 	}
 
-	// Execute request
-	actualJSON, err := r.client.Execute(ctx, string(query), variables)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to execute request: %w", err)
-		result.Duration = time.Since(start)
-		return result
-	}
-
 	// Apply transforms to actual response
-	if test.Transform != "" {
-		actualJSON, err = TransformJSON(test.Transform, actualJSON)
+	if len(test.Transform) > 0 {
+		actualJSON, err = TransformJSON(test.Transform, actualJSON, transformOpts)
 		if err != nil {
 			result.Error = fmt.Errorf("failed to transform actual response: %w", err)
 			result.Duration = time.Since(start)
@@ -179,16 +573,18 @@ func (r *Runner) RunTest(ctx context.Context, test *Test) *Result {
 	}
 
 	// Read expected response
-	expectedJSON, err := os.ReadFile(test.Response)
-	if err != nil {
+	var expectedJSON []byte
+	if tc != nil {
+		expectedJSON = tc.Response
+	} else if expectedJSON, err = os.ReadFile(test.Response); err != nil {
 		result.Error = fmt.Errorf("failed to read expected response: %w", err)
 		result.Duration = time.Since(start)
 		return result
 	}
 
 	// Apply transforms to expected response
-	if test.Transform != "" {
-		expectedJSON, err = TransformJSON(test.Transform, expectedJSON)
+	if len(test.Transform) > 0 {
+		expectedJSON, err = TransformJSON(test.Transform, expectedJSON, transformOpts)
 		if err != nil {
 			result.Error = fmt.Errorf("failed to transform expected response: %w", err)
 			result.Duration = time.Since(start)
@@ -199,16 +595,121 @@ func (r *Runner) RunTest(ctx context.Context, test *Test) *Result {
 	// Compare JSON
 	result.Expected = string(expectedJSON)
 	result.Actual = string(actualJSON)
-	result.Passed = jsonEqual(expectedJSON, actualJSON)
+	if cfg.Compare == CompareSubset {
+		result.Passed = jsonSubset(expectedJSON, actualJSON)
+	} else {
+		result.Passed = jsonEqual(expectedJSON, actualJSON)
+	}
 	result.Duration = time.Since(start)
 
 	if !result.Passed && result.Error == nil {
 		result.Error = fmt.Errorf("response mismatch")
 	}
+	checkBudget(result, cfg)
 
 	return result
 }
 
+// checkBudget fails a passing result that took longer than the test's
+// max_duration.
+func checkBudget(result *Result, cfg *SuiteConfig) {
+	if result.Passed && cfg.MaxDuration > 0 && result.Duration > cfg.MaxDuration {
+		result.Passed = false
+		result.Error = fmt.Errorf("took %v, over its max_duration of %v", result.Duration.Round(time.Millisecond), cfg.MaxDuration)
+	}
+}
+
+// execute sends the test's request to the API its RequestType names and
+// returns the raw JSON response. Variables of tc, if set, are layered over
+// the test's variables.json.
+func (r *Runner) execute(ctx context.Context, test *Test, cfg *SuiteConfig, tc *TestCase) ([]byte, error) {
+	if tc != nil && (test.RequestType == RequestAdmin || test.RequestType == RequestGRPC) {
+		return nil, fmt.Errorf("cases are only supported with request.gql")
+	}
+
+	var actualJSON []byte
+	var err error
+	switch test.RequestType {
+	case RequestAdmin:
+		if r.admin == nil {
+			return nil, fmt.Errorf("%s needs the admin API, but no admin endpoint is configured", AdminRequestFile)
+		}
+		adminReq, loadErr := LoadAdminRequest(test.Request)
+		if loadErr != nil {
+			return nil, fmt.Errorf("failed to read request: %w", loadErr)
+		}
+		actualJSON, err = r.admin.Do(ctx, adminReq, cfg.Headers)
+
+	case RequestGRPC:
+		if r.grpc == nil {
+			return nil, fmt.Errorf("%s needs gRPC, but no gRPC endpoint is configured", GRPCRequestFile)
+		}
+		grpcReq, loadErr := LoadGRPCRequest(test.Request)
+		if loadErr != nil {
+			return nil, fmt.Errorf("failed to read request: %w", loadErr)
+		}
+		callCtx := ctx
+		if r.options.Timeout > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeout(ctx, r.options.Timeout)
+			defer cancel()
+		}
+		actualJSON, err = r.grpc.Invoke(callCtx, grpcReq, cfg.Headers, cfg.Protoset)
+
+	default:
+		query, readErr := os.ReadFile(test.Request)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read request: %w", readErr)
+		}
+
+		// Read variables if present
+		var variables map[string]any
+		if test.Variables != "" {
+			varsData, err := os.ReadFile(test.Variables)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read variables: %w", err)
+			}
+			if err := json.Unmarshal(varsData, &variables); err != nil {
+				return nil, fmt.Errorf("failed to parse variables: %w", err)
+			}
+		}
+		if tc != nil && len(tc.Variables) > 0 {
+			var caseVars map[string]any
+			if err := json.Unmarshal(tc.Variables, &caseVars); err != nil {
+				return nil, fmt.Errorf("failed to parse variables of case %s: %w", tc.Name, err)
+			}
+			if variables == nil {
+				variables = make(map[string]any, len(caseVars))
+			}
+			for k, v := range caseVars {
+				variables[k] = v
+			}
+		}
+
+		actualJSON, err = r.client.ExecuteWithHeaders(ctx, string(query), variables, cfg.Headers)
+	}
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("test timed out after %v", cfg.Timeout)
+		}
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	return actualJSON, nil
+}
+
+// hasErrors reports whether a response carries GraphQL errors or a gRPC
+// error status.
+func hasErrors(data []byte) bool {
+	var resp struct {
+		Errors []any `json:"errors"`
+		Error  any   `json:"error"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return false
+	}
+	return len(resp.Errors) > 0 || resp.Error != nil
+}
+
 // collectAllTests returns all valid tests from all suites in proper execution order.
 // The order is: root base test first, then child tests sorted by sequence number.
 func (r *Runner) collectAllTests(suites Suites) []*Test {
@@ -239,6 +740,50 @@ func jsonEqual(a, b []byte) bool {
 	return string(aNorm) == string(bNorm)
 }
 
+// jsonSubset reports whether every field in expected is present with an
+// equal value in actual. Arrays must have the same length and are compared
+// element by element.
+func jsonSubset(expected, actual []byte) bool {
+	var eVal, aVal any
+	if err := json.Unmarshal(expected, &eVal); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(actual, &aVal); err != nil {
+		return false
+	}
+	return valueSubset(eVal, aVal)
+}
+
+func valueSubset(expected, actual any) bool {
+	switch e := expected.(type) {
+	case map[string]any:
+		a, ok := actual.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, ev := range e {
+			av, ok := a[k]
+			if !ok || !valueSubset(ev, av) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		a, ok := actual.([]any)
+		if !ok || len(a) != len(e) {
+			return false
+		}
+		for i := range e {
+			if !valueSubset(e[i], a[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return expected == actual
+	}
+}
+
 // truncate shortens a string to the given length.
 func truncate(s string, maxLen int) string {
 	s = strings.ReplaceAll(s, "\n", " ")
@@ -0,0 +1,451 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGraphQL is a GraphQL endpoint that answers every query with an empty
+// data object, or with an error for the queries in failing, and for those in
+// failOnce the first time they are sent. Queries in delays are answered
+// after waiting that long. It records the queries it was sent, in order, and
+// how many it answered at once at most.
+type fakeGraphQL struct {
+	mu          sync.Mutex
+	queries     []string
+	variables   []map[string]any // Of each query, in order
+	tenants     []string         // Account of each query, in order
+	failing     map[string]bool
+	failOnce    map[string]bool
+	delays      map[string]time.Duration
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *fakeGraphQL) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body GraphQLRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := strings.TrimSpace(body.Query)
+	f.mu.Lock()
+	f.queries = append(f.queries, query)
+	f.variables = append(f.variables, body.Variables)
+	f.tenants = append(f.tenants, req.Header.Get("X-Twisp-Account-Id"))
+	fail := f.failing[query] || f.failOnce[query]
+	delete(f.failOnce, query)
+	delay := f.delays[query]
+	f.inFlight++
+	f.maxInFlight = max(f.maxInFlight, f.inFlight)
+	f.mu.Unlock()
+
+	time.Sleep(delay)
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if fail {
+		io.WriteString(w, `{"errors": [{"message": "failed"}]}`)
+		return
+	}
+	io.WriteString(w, `{"data": {}}`)
+}
+
+// runSuite runs the suite at dir against fake and returns its result.
+func runSuite(t *testing.T, fake *fakeGraphQL, dir string, options Options) *SuiteResult {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	r := NewRunner(server.URL, options, "tenant", nil)
+	r.SetOutput(io.Discard)
+	result, err := r.RunSuite(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// queryFixture returns the files of a test whose request is query { name }.
+func queryFixture(dir, name string) map[string]string {
+	return map[string]string{
+		dir + "request.gql":   "query { " + name + " }",
+		dir + "response.json": `{"data": {}}`,
+	}
+}
+
+func merge(fixtures ...map[string]string) map[string]string {
+	files := make(map[string]string)
+	for _, f := range fixtures {
+		for k, v := range f {
+			files[k] = v
+		}
+	}
+	return files
+}
+
+func TestNestedHooksRunAroundTheirTest(t *testing.T) {
+	files := merge(
+		queryFixture("", "root"),
+		queryFixture("001_A/", "a"),
+		queryFixture("001_A/setup/", "a_setup"),
+		queryFixture("002_B/", "b"),
+		map[string]string{"001_A/after.gql": "query { a_after }"},
+	)
+
+	tests := []struct {
+		name    string
+		failing []string
+		queries []string
+		passed  int
+		failed  int
+		errText string
+	}{
+		{
+			name:    "hooks pass",
+			queries: []string{"root", "a_setup", "a", "a_after", "b"},
+			passed:  3,
+		},
+		{
+			name:    "setup fails",
+			failing: []string{"a_setup"},
+			queries: []string{"root", "a_setup", "a_after", "b"},
+			passed:  2,
+			failed:  1,
+			errText: "setup step 001_A/setup failed",
+		},
+		{
+			name:    "teardown fails",
+			failing: []string{"a_after"},
+			queries: []string{"root", "a_setup", "a", "a_after", "b"},
+			passed:  2,
+			failed:  1,
+			errText: "teardown step 001_A/after.gql failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, files)
+			fake := &fakeGraphQL{failing: make(map[string]bool)}
+			for _, q := range tt.failing {
+				fake.failing["query { "+q+" }"] = true
+			}
+
+			result := runSuite(t, fake, dir, Options{})
+
+			var got []string
+			for _, q := range fake.queries {
+				got = append(got, strings.TrimSuffix(strings.TrimPrefix(q, "query { "), " }"))
+			}
+			if !slices.Equal(got, tt.queries) {
+				t.Errorf("queries = %v, want %v", got, tt.queries)
+			}
+			if result.Passed != tt.passed || result.Failed != tt.failed {
+				t.Errorf("passed %d, failed %d; want %d and %d", result.Passed, result.Failed, tt.passed, tt.failed)
+			}
+			if tt.errText != "" {
+				found := false
+				for _, res := range result.Results {
+					if res.Error != nil && strings.Contains(res.Error.Error(), tt.errText) {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("no result with an error containing %q", tt.errText)
+				}
+			}
+		})
+	}
+}
+
+func TestHooksWithoutTestsAreRejected(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, merge(
+		queryFixture("group/setup/", "setup"),
+		queryFixture("group/check/", "check"),
+	))
+	_, err := DiscoverTests(dir)
+	if err == nil || !strings.Contains(err.Error(), "'group' has setup or teardown steps") {
+		t.Errorf("error = %v, want one about group's hooks", err)
+	}
+}
+
+func TestSelectTests(t *testing.T) {
+	tests := []struct {
+		name    string
+		needs   string // needs of 003_C, if any
+		dirs    []string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "sequenced test with the tests before it",
+			dirs: []string{"002_B"},
+			want: []string{"", "001_A", "002_B"},
+		},
+		{
+			name: "several tests",
+			dirs: []string{"003_C", "001_A"},
+			want: []string{"", "001_A", "002_B", "003_C"},
+		},
+		{
+			name:  "test with needs",
+			needs: "[001_A]",
+			dirs:  []string{"003_C"},
+			want:  []string{"", "001_A", "003_C"},
+		},
+		{
+			name:    "unknown dir",
+			dirs:    []string{"002_B", "setup"},
+			wantErr: "setup is not a test of the suite",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := merge(queryFixture("", "root"), queryFixture("001_A/", "a"), queryFixture("002_B/", "b"), queryFixture("003_C/", "c"))
+			if tt.needs != "" {
+				files["003_C/suite.yaml"] = "needs: " + tt.needs + "\n"
+			}
+			writeFiles(t, dir, files)
+			suites, err := DiscoverTests(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ordered := suites.GetOrderedTests("")
+			var graph *testGraph
+			if hasNeeds(ordered) {
+				if graph, err = newTestGraph(suites, ordered); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			selected, err := selectTests(suites, ordered, graph, tt.dirs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, test := range selected {
+				got = append(got, test.Dir)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selected %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// queryNames returns the names of the queries fake was sent, in order.
+func queryNames(fake *fakeGraphQL) []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	var names []string
+	for _, q := range fake.queries {
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(q, "query { "), " }"))
+	}
+	return names
+}
+
+// groupedFixture returns a suite with two sequenced tests and the given
+// unsequenced ones, grouped into the suite by group_unsequenced.
+func groupedFixture(unsequenced ...string) map[string]string {
+	files := merge(
+		queryFixture("", "root"),
+		queryFixture("001_A/", "a"),
+		queryFixture("002_B/", "b"),
+		map[string]string{"suite.yaml": "group_unsequenced: true\n"},
+	)
+	for _, name := range unsequenced {
+		files = merge(files, queryFixture(name+"/", name))
+	}
+	return files
+}
+
+func TestUnsequencedTestsAreGroupedOnlyWhenAsked(t *testing.T) {
+	for _, grouped := range []bool{false, true} {
+		dir := t.TempDir()
+		files := groupedFixture("check_x", "check_y")
+		if !grouped {
+			delete(files, "suite.yaml")
+		}
+		writeFiles(t, dir, files)
+		suites, err := DiscoverTests(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{""}
+		if !grouped {
+			want = []string{"", "check_x", "check_y"}
+		}
+		if got := suites.RunnableSuitePaths(); !slices.Equal(got, want) {
+			t.Errorf("grouped %v: runnable suites %q, want %q", grouped, got, want)
+		}
+	}
+}
+
+func TestUnsequencedTestsRunConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, groupedFixture("check_x", "check_y", "check_z"))
+	fake := &fakeGraphQL{delays: map[string]time.Duration{
+		// The first check finishes last, yet is recorded first
+		"query { check_x }": 100 * time.Millisecond,
+		"query { check_y }": 50 * time.Millisecond,
+		"query { check_z }": 50 * time.Millisecond,
+	}}
+
+	result := runSuite(t, fake, dir, Options{ParallelTests: 3})
+
+	queries := queryNames(fake)
+	if len(queries) != 6 || !slices.Equal(queries[:3], []string{"root", "a", "b"}) {
+		t.Errorf("queries = %v, want root, a and b in order before the checks", queries)
+	}
+	if fake.maxInFlight != 3 {
+		t.Errorf("at most %d queries ran at once, want the 3 checks together", fake.maxInFlight)
+	}
+	var dirs []string
+	for _, res := range result.Results {
+		dirs = append(dirs, res.Test.Dir)
+	}
+	if want := []string{"", "001_A", "002_B", "check_x", "check_y", "check_z"}; !slices.Equal(dirs, want) {
+		t.Errorf("results in order %q, want %q", dirs, want)
+	}
+	if result.Passed != 6 {
+		t.Errorf("passed %d, want 6", result.Passed)
+	}
+}
+
+func TestUnsequencedTestsStopOnFailFast(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, groupedFixture("check_1", "check_2", "check_3", "check_4", "check_5"))
+	fake := &fakeGraphQL{
+		failing: map[string]bool{"query { check_1 }": true},
+		delays:  map[string]time.Duration{"query { check_2 }": 100 * time.Millisecond},
+	}
+
+	result := runSuite(t, fake, dir, Options{ParallelTests: 2, FailFast: true})
+
+	queries := queryNames(fake)
+	// check_3 may already be on its way to the worker that ran check_1
+	for _, late := range []string{"check_4", "check_5"} {
+		if slices.Contains(queries, late) {
+			t.Errorf("%s ran after check_1 failed: %v", late, queries)
+		}
+	}
+	if result.Failed != 1 {
+		t.Errorf("failed %d, want 1", result.Failed)
+	}
+	if len(result.Results) != len(queries) {
+		t.Errorf("%d results for %d queries", len(result.Results), len(queries))
+	}
+}
+
+func TestCasesRunOncePerRow(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, merge(queryFixture("", "root"), map[string]string{
+		"001_Lookup/request.gql":    "query { lookup }",
+		"001_Lookup/variables.json": `{"currency": "XXX", "limit": 10}`,
+		"001_Lookup/" + CasesFile: `{"name": "usd", "variables": {"currency": "USD"}, "response": {"data": {}}}
+{"variables": {"currency": "EUR"}}
+{"variables": {"currency": "JPY"}, "response": {"data": {"rate": 1}}}
+`,
+		"001_Lookup/" + CasesDir + "/gbp/variables.json": `{"currency": "GBP"}`,
+		"001_Lookup/" + CasesDir + "/gbp/response.json":  `{"data": {}}`,
+	}))
+	fake := &fakeGraphQL{}
+
+	result := runSuite(t, fake, dir, Options{})
+
+	var currencies []string
+	for i, q := range fake.queries {
+		if q != "query { lookup }" {
+			continue
+		}
+		vars := fake.variables[i]
+		if vars["limit"] != float64(10) {
+			t.Errorf("case %d: limit = %v, want 10 from variables.json", len(currencies)+1, vars["limit"])
+		}
+		currency, _ := vars["currency"].(string)
+		currencies = append(currencies, currency)
+	}
+	if want := []string{"USD", "EUR", "JPY", "GBP"}; !slices.Equal(currencies, want) {
+		t.Errorf("currencies sent %q, want %q", currencies, want)
+	}
+
+	var names []string
+	for _, res := range result.Results {
+		names = append(names, res.Name())
+	}
+	if want := []string{"", "001_Lookup/usd", "001_Lookup/case_002", "001_Lookup/case_003", "001_Lookup/gbp"}; !slices.Equal(names, want) {
+		t.Errorf("results %q, want %q", names, want)
+	}
+	// A case without a response passes if the response has no errors
+	if result.Passed != 4 || result.Failed != 1 {
+		t.Errorf("passed %d, failed %d; want 4 and 1", result.Passed, result.Failed)
+	}
+	for _, res := range result.Results {
+		if res.Case == "case_003" && res.Passed {
+			t.Error("case_003 passed, but its response does not match")
+		}
+	}
+}
+
+func TestFailedTestIsRetriedInAFreshTenant(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, merge(
+		queryFixture("", "root"),
+		queryFixture("setup/", "setup"),
+		queryFixture("001_A/", "a"),
+		queryFixture("002_B/", "b"),
+		queryFixture("003_C/", "c"),
+	))
+	fake := &fakeGraphQL{failOnce: map[string]bool{"query { b }": true}}
+
+	result := runSuite(t, fake, dir, Options{Retries: 2})
+
+	// The retry replays the setup and the tests before 002_B first
+	queries := queryNames(fake)
+	if want := []string{"setup", "root", "a", "b", "setup", "root", "a", "b", "c"}; !slices.Equal(queries, want) {
+		t.Fatalf("queries = %v, want %v", queries, want)
+	}
+	for i, tenant := range fake.tenants {
+		retry := i >= 4 && i < 8
+		if retry == (tenant == "tenant") {
+			t.Errorf("%s (query %d) ran as tenant %q", queries[i], i+1, tenant)
+		}
+	}
+	if !slices.Equal(fake.tenants[4:8], slices.Repeat(fake.tenants[4:5], 4)) {
+		t.Errorf("retry ran in several tenants: %q", fake.tenants[4:8])
+	}
+
+	for _, res := range result.Results {
+		if res.Test.Dir != "002_B" {
+			if res.Flaky || res.Attempts != 0 {
+				t.Errorf("%s: flaky %v, attempts %d; want neither", res.Name(), res.Flaky, res.Attempts)
+			}
+			continue
+		}
+		if !res.Passed || !res.Flaky || res.Attempts != 2 {
+			t.Errorf("002_B: passed %v, flaky %v, attempts %d; want a flaky pass on attempt 2", res.Passed, res.Flaky, res.Attempts)
+		}
+	}
+	if result.Passed != 5 || result.Failed != 0 || result.Flaky != 1 {
+		t.Errorf("passed %d, failed %d, flaky %d; want 5, 0 and 1", result.Passed, result.Failed, result.Flaky)
+	}
+}
@@ -0,0 +1,265 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SuiteConfigFile is the name of the optional per-directory config file.
+const SuiteConfigFile = "suite.yaml"
+
+// CompareMode selects how actual and expected responses are compared.
+type CompareMode string
+
+const (
+	// CompareExact requires the responses to be semantically equal JSON.
+	CompareExact CompareMode = "exact"
+	// CompareSubset requires every field of the expected response to be
+	// present and equal in the actual response. Extra actual fields are ignored.
+	CompareSubset CompareMode = "subset"
+)
+
+// TransformFile is the name of the per-directory jq transform file.
+const TransformFile = "transform.jq"
+
+// SuiteConfig holds defaults declared in suite.yaml files. A directory's
+// effective config is its own file layered over its parent's effective
+// config, so settings are inherited by all descendants. Inheritance starts
+// at the suite root, such as the --test_suite_path a suite was found under,
+// or below it at a file that sets root: true. Files above the suite root
+// are ignored.
+type SuiteConfig struct {
+	Root        bool              // Stop inheriting from parent directories
+	Transforms  []string          // jq transform chain, outermost directory first
+	JQLibraries []string          // jq module directories, nearest directory first
+	Headers     map[string]string // Extra request headers
+	Timeout     time.Duration     // Per-test timeout (0 for none)
+	MaxDuration time.Duration     // Latency budget; a slower test fails (0 for none)
+	Compare     CompareMode       // Response comparison mode
+	Isolated    bool              // Suite needs a dedicated, fresh container
+	Grouped     bool              // Unsequenced tests under a test directory join its suite
+	Protoset    string            // Descriptor set for gRPC steps ("" for server reflection)
+
+	inheritTransforms bool     // Whether Transforms extends the parent's chain
+	isolated          *bool    // Isolated as declared, nil if not set here
+	grouped           *bool    // Grouped as declared, nil if not set here
+	needs             []string // Tests the directory's test depends on; never inherited
+	readOnly          bool     // The directory's test changes no state; never inherited
+}
+
+// suiteConfigFile is the on-disk layout of suite.yaml.
+type suiteConfigFile struct {
+	Root              bool              `yaml:"root"`
+	Transform         string            `yaml:"transform"`
+	InheritTransforms *bool             `yaml:"inherit_transforms"`
+	JQLibrary         string            `yaml:"jq_library"`
+	Headers           map[string]string `yaml:"headers"`
+	Timeout           string            `yaml:"timeout"`
+	MaxDuration       string            `yaml:"max_duration"`
+	Compare           CompareMode       `yaml:"compare"`
+	Isolated          *bool             `yaml:"isolated"`
+	GroupUnsequenced  *bool             `yaml:"group_unsequenced"`
+	GRPCProtoset      string            `yaml:"grpc_protoset"`
+	Needs             []string          `yaml:"needs"`
+	ReadOnly          bool              `yaml:"read_only"`
+}
+
+// LoadSuiteConfig returns the effective config for the directory dir,
+// including everything inherited from its ancestors up to root. An empty
+// root stands for dir itself.
+func LoadSuiteConfig(root, dir string) (*SuiteConfig, error) {
+	if root == "" {
+		root = dir
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	return resolveSuiteConfig(absRoot, absDir)
+}
+
+// resolveSuiteConfig walks up from dir to root collecting suite.yaml files
+// and merges them outermost first. If dir is not inside root, only dir's
+// own files apply.
+func resolveSuiteConfig(root, dir string) (*SuiteConfig, error) {
+	var chain []*SuiteConfig
+	for d := dir; ; {
+		cfg, err := readSuiteConfig(d)
+		if err != nil {
+			return nil, err
+		}
+		if cfg != nil {
+			chain = append(chain, cfg)
+			if cfg.Root {
+				break
+			}
+		}
+		parent := filepath.Dir(d)
+		if d == root || parent == d || !isWithin(root, parent) {
+			break
+		}
+		d = parent
+	}
+
+	effective := &SuiteConfig{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		effective = chain[i].inherit(effective)
+	}
+	return effective, nil
+}
+
+// isWithin reports whether path is root or a directory below it. Both are
+// absolute.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readSuiteConfig returns the settings declared by dir itself: its
+// suite.yaml and its transform.jq. It returns nil if neither exists.
+// Relative transform and library paths are resolved against dir.
+func readSuiteConfig(dir string) (*SuiteConfig, error) {
+	var transformJQ string
+	if info, err := os.Stat(filepath.Join(dir, TransformFile)); err == nil && !info.IsDir() {
+		transformJQ = filepath.Join(dir, TransformFile)
+	}
+
+	path := filepath.Join(dir, SuiteConfigFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if transformJQ == "" {
+			return nil, nil
+		}
+		return &SuiteConfig{Transforms: []string{transformJQ}, inheritTransforms: true}, nil
+	}
+
+	var file suiteConfigFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	cfg := &SuiteConfig{
+		Root:              file.Root,
+		Headers:           file.Headers,
+		Compare:           file.Compare,
+		inheritTransforms: file.InheritTransforms == nil || *file.InheritTransforms,
+		isolated:          file.Isolated,
+		grouped:           file.GroupUnsequenced,
+		needs:             file.Needs,
+		readOnly:          file.ReadOnly,
+	}
+	if file.Timeout != "" {
+		d, err := time.ParseDuration(file.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %q in %s: %w", file.Timeout, path, err)
+		}
+		cfg.Timeout = d
+	}
+	if file.MaxDuration != "" {
+		d, err := time.ParseDuration(file.MaxDuration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_duration %q in %s: %w", file.MaxDuration, path, err)
+		}
+		cfg.MaxDuration = d
+	}
+	switch cfg.Compare {
+	case "", CompareExact, CompareSubset:
+	default:
+		return nil, fmt.Errorf("invalid compare mode %q in %s (expected %q or %q)", cfg.Compare, path, CompareExact, CompareSubset)
+	}
+	// A transform named in suite.yaml runs before the directory's transform.jq
+	if file.Transform != "" {
+		if !filepath.IsAbs(file.Transform) {
+			file.Transform = filepath.Join(dir, file.Transform)
+		}
+		cfg.Transforms = append(cfg.Transforms, file.Transform)
+	}
+	if transformJQ != "" {
+		cfg.Transforms = append(cfg.Transforms, transformJQ)
+	}
+	if file.GRPCProtoset != "" {
+		cfg.Protoset = file.GRPCProtoset
+		if !filepath.IsAbs(cfg.Protoset) {
+			cfg.Protoset = filepath.Join(dir, cfg.Protoset)
+		}
+	}
+	if file.JQLibrary != "" {
+		if !filepath.IsAbs(file.JQLibrary) {
+			file.JQLibrary = filepath.Join(dir, file.JQLibrary)
+		}
+		cfg.JQLibraries = []string{file.JQLibrary}
+	}
+	return cfg, nil
+}
+
+// inherit returns the effective config of c layered over parent.
+func (c *SuiteConfig) inherit(parent *SuiteConfig) *SuiteConfig {
+	if c == nil {
+		return parent
+	}
+	if c.Root || parent == nil {
+		parent = &SuiteConfig{}
+	}
+
+	merged := *parent
+	merged.Root = false
+	merged.inheritTransforms = true
+	if !c.inheritTransforms {
+		merged.Transforms = nil
+	}
+	if len(c.Transforms) > 0 {
+		merged.Transforms = append(append([]string(nil), merged.Transforms...), c.Transforms...)
+	}
+	if len(c.JQLibraries) > 0 {
+		merged.JQLibraries = append(append([]string(nil), c.JQLibraries...), merged.JQLibraries...)
+	}
+	if len(c.Headers) > 0 {
+		merged.Headers = make(map[string]string, len(parent.Headers)+len(c.Headers))
+		for k, v := range parent.Headers {
+			merged.Headers[k] = v
+		}
+		for k, v := range c.Headers {
+			merged.Headers[k] = v
+		}
+	}
+	if c.Timeout > 0 {
+		merged.Timeout = c.Timeout
+	}
+	if c.MaxDuration > 0 {
+		merged.MaxDuration = c.MaxDuration
+	}
+	if c.Compare != "" {
+		merged.Compare = c.Compare
+	}
+	if c.Protoset != "" {
+		merged.Protoset = c.Protoset
+	}
+	if c.isolated != nil {
+		merged.Isolated = *c.isolated
+	}
+	if c.grouped != nil {
+		merged.Grouped = *c.grouped
+	}
+	merged.isolated = nil
+	merged.grouped = nil
+	merged.needs = nil
+	merged.readOnly = false
+	return &merged
+}
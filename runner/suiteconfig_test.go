@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFiles creates files under dir from a map of relative path to content.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadSuiteConfigStopsAtRoot(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"suite.yaml":                  "headers: {X-Outside: \"1\"}\ncompare: subset\n",
+		"fixtures/suite.yaml":         "headers: {X-Root: \"1\"}\ntimeout: 5s\n",
+		"fixtures/ledger/suite.yaml":  "headers: {X-Suite: \"1\"}\n",
+		"fixtures/ledger/001_A/x.txt": "",
+	})
+	root := filepath.Join(dir, "fixtures")
+
+	tests := []struct {
+		name    string
+		root    string
+		dir     string
+		headers []string
+		timeout time.Duration
+		compare CompareMode
+	}{
+		{
+			name:    "nested suite inherits up to the root",
+			root:    root,
+			dir:     filepath.Join(root, "ledger", "001_A"),
+			headers: []string{"X-Root", "X-Suite"},
+			timeout: 5 * time.Second,
+		},
+		{
+			name:    "root itself",
+			root:    root,
+			dir:     root,
+			headers: []string{"X-Root"},
+			timeout: 5 * time.Second,
+		},
+		{
+			name:    "suite as its own root",
+			root:    filepath.Join(root, "ledger"),
+			dir:     filepath.Join(root, "ledger"),
+			headers: []string{"X-Suite"},
+		},
+		{
+			name:    "empty root is the directory itself",
+			root:    "",
+			dir:     filepath.Join(root, "ledger"),
+			headers: []string{"X-Suite"},
+		},
+		{
+			name:    "directory outside the root",
+			root:    filepath.Join(root, "ledger"),
+			dir:     root,
+			headers: []string{"X-Root"},
+			timeout: 5 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadSuiteConfig(tt.root, tt.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.Headers) != len(tt.headers) {
+				t.Errorf("headers = %v, want %v", cfg.Headers, tt.headers)
+			}
+			for _, h := range tt.headers {
+				if _, ok := cfg.Headers[h]; !ok {
+					t.Errorf("headers = %v, want %v", cfg.Headers, tt.headers)
+				}
+			}
+			if cfg.Timeout != tt.timeout {
+				t.Errorf("timeout = %v, want %v", cfg.Timeout, tt.timeout)
+			}
+			if cfg.Compare != tt.compare {
+				t.Errorf("compare = %q, want %q", cfg.Compare, tt.compare)
+			}
+		})
+	}
+}
+
+func TestLoadSuiteConfigRootTrue(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"suite.yaml":        "headers: {X-Root: \"1\"}\n",
+		"ledger/suite.yaml": "root: true\nheaders: {X-Suite: \"1\"}\n",
+	})
+	cfg, err := LoadSuiteConfig(dir, filepath.Join(dir, "ledger"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Headers["X-Root"]; ok || len(cfg.Headers) != 1 {
+		t.Errorf("headers = %v, want only X-Suite", cfg.Headers)
+	}
+}
@@ -1,92 +1,317 @@
 package runner
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/itchyny/gojq"
 )
 
-// TransformJSON applies JQ transforms from the given transform file to the JSON data.
-// Each line in the transform file is treated as a separate JQ filter.
-// Filters are applied sequentially, with each filter receiving the output of the previous.
-func TransformJSON(transformFile string, jsonData []byte) ([]byte, error) {
-	if transformFile == "" {
-		return jsonData, nil
-	}
+// collectDirective, on a line of its own in a transform file, makes every
+// filter in the file return an array of all its outputs instead of only the
+// first one.
+const collectDirective = "# @collect"
 
-	// Read transform filters from file
-	transforms, err := readTransformFile(transformFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read transform file: %w", err)
-	}
+// programDirective, on a line of its own in a transform file, makes the
+// whole file one jq program, which may span lines and use def, import and
+// comments. Without it, every line is a filter of its own.
+const programDirective = "# @program"
+
+// transformVariables are the variables every transform program is compiled
+// with. Values are supplied from TransformOptions in the same order.
+var transformVariables = []string{"$accountId", "$testName"}
 
-	if len(transforms) == 0 {
+// TransformOptions configures how transform files are compiled and run.
+type TransformOptions struct {
+	// LibraryDirs are searched, in order, for modules named in jq import and
+	// include statements, e.g. import "timestamps" as ts; loads timestamps.jq.
+	LibraryDirs []string
+
+	AccountID string // Exposed to programs as $accountId
+	TestName  string // Exposed to programs as $testName
+}
+
+// transformCache holds compiled transform files across tests and suites.
+// Entries are keyed by path and library directories and are recompiled when
+// the file's size or modification time changes. The library directories are
+// stamped the first time they are used and then only by
+// RefreshTransformLibraries, which drops the entries built on modules that
+// changed since.
+var transformCache = struct {
+	sync.Mutex
+	entries   map[string]*cachedTransform
+	libraries map[string]string // libraryStamp by libraryKey
+}{entries: make(map[string]*cachedTransform), libraries: make(map[string]string)}
+
+type cachedTransform struct {
+	modTime   time.Time
+	size      int64
+	libraries string // libraryKey of the library directories
+	program   *transformProgram
+}
+
+// transformProgram is a compiled transform file.
+type transformProgram struct {
+	path    string
+	filters []transformFilter // Applied in sequence
+	collect bool              // Gather all outputs of each filter into an array
+}
+
+// transformFilter is one compiled jq filter and its source for error messages.
+type transformFilter struct {
+	code   *gojq.Code
+	source string
+}
+
+// TransformJSON applies the JQ transforms from the given transform files to
+// the JSON data. Files are applied in order, so ancestor transforms listed
+// first run before a test's own transform.
+//
+// A transform file is a list of one-line filters that are applied
+// sequentially, each receiving the output of the previous. A file with a
+// "# @program" line is instead a single jq program, which may span multiple
+// lines and use def, import, comments and so on.
+func TransformJSON(transformFiles []string, jsonData []byte, opts TransformOptions) ([]byte, error) {
+	if len(transformFiles) == 0 {
 		return jsonData, nil
 	}
 
+	var programs []*transformProgram
+	for _, transformFile := range transformFiles {
+		program, err := loadTransformFile(transformFile, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read transform file: %w", err)
+		}
+		programs = append(programs, program)
+	}
+
 	// Parse JSON into a generic structure
 	var data any
 	if err := json.Unmarshal(jsonData, &data); err != nil {
 		return nil, fmt.Errorf("failed to parse JSON: %w", err)
 	}
 
-	// Apply each transform sequentially
-	for _, xform := range transforms {
-		if xform == "" {
-			continue
+	values := []any{opts.AccountID, opts.TestName}
+	for _, program := range programs {
+		for _, filter := range program.filters {
+			val, err := runFilter(filter, data, values, program.collect)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", program.path, err)
+			}
+			data = val
 		}
+	}
 
-		query, err := gojq.Parse(xform)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse jq expression '%s': %w", xform, err)
-		}
+	// Marshal back to JSON
+	result, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transformed JSON: %w", err)
+	}
 
-		iter := query.Run(data)
+	return result, nil
+}
+
+// runFilter runs filter against data and returns its first output, or all
+// outputs as an array when collect is set.
+func runFilter(filter transformFilter, data any, values []any, collect bool) (any, error) {
+	var outputs []any
+	iter := filter.code.Run(data, values...)
+	for {
 		val, ok := iter.Next()
 		if !ok {
-			return nil, fmt.Errorf("jq expression '%s' produced no output", xform)
+			break
 		}
-
 		if err, isErr := val.(error); isErr {
-			return nil, fmt.Errorf("jq expression '%s' failed: %w", xform, err)
+			return nil, fmt.Errorf("jq expression '%s' failed: %w", truncate(filter.source, 120), err)
 		}
+		if !collect {
+			return val, nil
+		}
+		outputs = append(outputs, val)
+	}
 
-		data = val
+	if !collect {
+		return nil, fmt.Errorf("jq expression '%s' produced no output", truncate(filter.source, 120))
 	}
+	if outputs == nil {
+		outputs = []any{}
+	}
+	return outputs, nil
+}
 
-	// Marshal back to JSON
-	result, err := json.Marshal(data)
+// loadTransformFile returns the compiled transform file at path, compiling it
+// only if it is not cached or has changed on disk.
+func loadTransformFile(path string, opts TransformOptions) (*transformProgram, error) {
+	info, err := os.Stat(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal transformed JSON: %w", err)
+		return nil, err
 	}
+	libraries := libraryKey(opts.LibraryDirs)
+	key := path + "\x00" + libraries
 
-	return result, nil
-}
+	transformCache.Lock()
+	cached, ok := transformCache.entries[key]
+	if _, stamped := transformCache.libraries[libraries]; !stamped {
+		transformCache.libraries[libraries] = libraryStamp(opts.LibraryDirs)
+	}
+	transformCache.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.program, nil
+	}
 
-// readTransformFile reads a transform file and returns the list of JQ filters.
-func readTransformFile(path string) ([]string, error) {
-	f, err := os.Open(path)
+	program, err := readTransformFile(path, opts)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	var transforms []string
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// Skip empty lines and comments
-		if line != "" && line[0] != '#' {
-			transforms = append(transforms, line)
+	transformCache.Lock()
+	transformCache.entries[key] = &cachedTransform{
+		modTime:   info.ModTime(),
+		size:      info.Size(),
+		libraries: libraries,
+		program:   program,
+	}
+	transformCache.Unlock()
+	return program, nil
+}
+
+// RefreshTransformLibraries looks at the jq library directories used so far
+// for modules added, removed or edited since they were last stamped, and
+// drops the compiled transforms that may import them. Within a run the
+// libraries are taken not to change; --watch calls this before rerunning.
+func RefreshTransformLibraries() {
+	transformCache.Lock()
+	defer transformCache.Unlock()
+	for libraries, stamp := range transformCache.libraries {
+		var dirs []string
+		if libraries != "" {
+			dirs = strings.Split(libraries, "\x00")
+		}
+		if fresh := libraryStamp(dirs); fresh != stamp {
+			transformCache.libraries[libraries] = fresh
+			for key, cached := range transformCache.entries {
+				if cached.libraries == libraries {
+					delete(transformCache.entries, key)
+				}
+			}
 		}
 	}
+}
+
+// libraryKey returns the key under which transforms compiled against the
+// library directories dirs are cached.
+func libraryKey(dirs []string) string {
+	return strings.Join(dirs, "\x00")
+}
 
-	if err := scanner.Err(); err != nil {
+// libraryStamp returns a summary of the names, sizes and modification times
+// of the jq modules and JSON data files in dirs, which changes whenever one
+// of them is added, removed or edited.
+func libraryStamp(dirs []string) string {
+	var b strings.Builder
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if ext := filepath.Ext(path); ext != ".jq" && ext != ".json" {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(&b, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+	}
+	return b.String()
+}
+
+// readTransformFile reads, parses and compiles a transform file.
+func readTransformFile(path string, opts TransformOptions) (*transformProgram, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
 		return nil, err
 	}
 
-	return transforms, nil
+	program := &transformProgram{path: path}
+
+	// Collect the one-line filters, skipping empty lines and comments
+	type filterLine struct {
+		number int
+		source string
+	}
+	var lines []filterLine
+	whole := false
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch strings.TrimSpace(line) {
+		case collectDirective:
+			program.collect = true
+			continue
+		case programDirective:
+			whole = true
+			continue
+		}
+		if trimmed := strings.TrimSpace(line); trimmed != "" && trimmed[0] != '#' {
+			lines = append(lines, filterLine{number: i + 1, source: line})
+		}
+	}
+	if len(lines) == 0 {
+		return program, nil
+	}
+
+	var queries []*gojq.Query
+	if whole {
+		query, err := gojq.Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse jq program in %s%s: %w", path, parseErrorLocation(string(data), err), err)
+		}
+		queries = []*gojq.Query{query}
+	} else {
+		for _, line := range lines {
+			query, err := gojq.Parse(line.source)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse jq filter on line %d of %s: %w (add a %q line to read the file as one program)", line.number, path, err, programDirective)
+			}
+			// A line holding only imports or defs parses, but is not a filter
+			if query.Term == nil && query.Op == 0 {
+				return nil, fmt.Errorf("line %d of %s is not a filter (add a %q line to read the file as one program)", line.number, path, programDirective)
+			}
+			queries = append(queries, query)
+		}
+	}
+
+	compilerOpts := []gojq.CompilerOption{gojq.WithVariables(transformVariables)}
+	if len(opts.LibraryDirs) > 0 {
+		compilerOpts = append(compilerOpts, gojq.WithModuleLoader(gojq.NewModuleLoader(opts.LibraryDirs)))
+	}
+	for _, query := range queries {
+		code, err := gojq.Compile(query, compilerOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile jq program in %s: %w", path, err)
+		}
+		program.filters = append(program.filters, transformFilter{code: code, source: query.String()})
+	}
+	return program, nil
+}
+
+// parseErrorLocation describes where in src a gojq parse error occurred, or
+// returns "" if the error carries no offset.
+func parseErrorLocation(src string, err error) string {
+	pe, ok := err.(*gojq.ParseError)
+	if !ok {
+		return ""
+	}
+	offset := min(pe.Offset, len(src))
+	line := strings.Count(src[:offset], "\n") + 1
+	col := offset - strings.LastIndex(src[:offset], "\n")
+	return fmt.Sprintf(" at line %d, column %d", line, col)
 }
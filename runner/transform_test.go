@@ -0,0 +1,121 @@
+package runner
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransformJSON(t *testing.T) {
+	const input = `{"a": {"b": 1, "c": [1, 2, 3]}, "created": "now"}`
+	tests := []struct {
+		name      string
+		transform string
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "one filter per line",
+			transform: "# Applied in order\ndel(.created)\n.a\n\n.c\n",
+			want:      `[1,2,3]`,
+		},
+		{
+			name:      "continued line without a program",
+			transform: ".a\n| .c\n| length\n",
+			wantErr:   "line 2",
+		},
+		{
+			name:      "program",
+			transform: "# @program\n.a\n| .c\n| length\n",
+			want:      `3`,
+		},
+		{
+			name:      "program with defs and comments",
+			transform: "# @program\ndef double: . * 2;\n\n# Sum, then double\n.a.c | add\n  | double\n",
+			want:      `12`,
+		},
+		{
+			name:      "def without a program",
+			transform: "def double: . * 2;\n.a.b | double\n",
+			wantErr:   "not a filter",
+		},
+		{
+			name:      "first output only",
+			transform: ".a.c[]\n",
+			want:      `1`,
+		},
+		{
+			name:      "collect",
+			transform: "# @collect\n.a.c[] | . * 10\n",
+			want:      `[10,20,30]`,
+		},
+		{
+			name:      "collect in a program",
+			transform: "# @program\n# @collect\n.a.c[]\n| select(. > 1)\n",
+			want:      `[2,3]`,
+		},
+		{
+			name:      "program parse error",
+			transform: "# @program\n.a |\n",
+			wantErr:   "failed to parse jq program",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), TransformFile)
+			writeFiles(t, filepath.Dir(path), map[string]string{TransformFile: tt.transform})
+
+			got, err := TransformJSON([]string{path}, []byte(input), TransformOptions{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformCacheSeesLibraryEditsOnRefresh(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib")
+	writeFiles(t, dir, map[string]string{
+		TransformFile:   "# @program\nimport \"fields\" as f;\nf::pick\n",
+		"lib/fields.jq": "def pick: .a;\n",
+	})
+	path := filepath.Join(dir, TransformFile)
+	opts := TransformOptions{LibraryDirs: []string{lib}}
+
+	got, err := TransformJSON([]string{path}, []byte(`{"a": 1, "bb": 2}`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `1` {
+		t.Fatalf("got %s, want 1", got)
+	}
+
+	// Within a run the libraries are not looked at again
+	writeFiles(t, dir, map[string]string{"lib/fields.jq": "def pick: .bb;\n"})
+	got, err = TransformJSON([]string{path}, []byte(`{"a": 1, "bb": 2}`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `1` {
+		t.Errorf("got %s before refreshing the libraries, want 1 from the cache", got)
+	}
+
+	RefreshTransformLibraries()
+	got, err = TransformJSON([]string{path}, []byte(`{"a": 1, "bb": 2}`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `2` {
+		t.Errorf("got %s after editing the module, want 2", got)
+	}
+}
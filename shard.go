@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shard is one of n parts of the suite set, numbered from 1.
+type shard struct {
+	index, count int
+}
+
+// parseShard parses a --shard value of the form "i/n".
+func parseShard(s string) (shard, error) {
+	is, ns, ok := strings.Cut(s, "/")
+	index, err1 := strconv.Atoi(strings.TrimSpace(is))
+	count, err2 := strconv.Atoi(strings.TrimSpace(ns))
+	if !ok || err1 != nil || err2 != nil || count < 1 || index < 1 || index > count {
+		return shard{}, fmt.Errorf("invalid shard %q (expected i/n with 1 <= i <= n)", s)
+	}
+	return shard{index: index, count: count}, nil
+}
+
+// shardSuites returns the suites of the given shard. Every shard computes
+// the same split from the same suites and timings, so CI jobs need not
+// coordinate. With recorded durations, suites are assigned longest first to
+// the shard with the least work so far. Without any, suites are assigned by
+// a hash of their path. The suites keep their order, and the shard's
+// expected duration is returned.
+func shardSuites(suites []string, s shard, timings *suiteTimings) ([]string, time.Duration, bool) {
+	durations, balanced := expectedDurations(suites, timings)
+
+	assigned := make(map[string]int, len(suites))
+	var expected time.Duration
+	if balanced {
+		load := make([]time.Duration, s.count)
+		for _, suitePath := range longestFirst(suites, durations) {
+			least := 0
+			for i := range load {
+				if load[i] < load[least] {
+					least = i
+				}
+			}
+			assigned[suitePath] = least
+			load[least] += durations[suitePath]
+		}
+		expected = load[s.index-1]
+	} else {
+		for _, suitePath := range suites {
+			h := fnv.New32a()
+			h.Write([]byte(filepath.Clean(suitePath)))
+			assigned[suitePath] = int(h.Sum32() % uint32(s.count))
+		}
+	}
+
+	var selected []string
+	for _, suitePath := range suites {
+		if assigned[suitePath] == s.index-1 {
+			selected = append(selected, suitePath)
+		}
+	}
+	return selected, expected, balanced
+}
+
+// expectedDurations returns how long each suite is expected to take, from
+// the recorded durations. Suites without one count as the average. It
+// returns false if none of the suites has a recorded duration.
+func expectedDurations(suites []string, timings *suiteTimings) (map[string]time.Duration, bool) {
+	var total time.Duration
+	known := 0
+	for _, suitePath := range suites {
+		if d, ok := timings.duration(suitePath); ok {
+			total += d
+			known++
+		}
+	}
+	if known == 0 {
+		return nil, false
+	}
+
+	average := total / time.Duration(known)
+	durations := make(map[string]time.Duration, len(suites))
+	for _, suitePath := range suites {
+		d, ok := timings.duration(suitePath)
+		if !ok {
+			d = average
+		}
+		durations[suitePath] = d
+	}
+	return durations, true
+}
+
+// timingsDigest returns a short digest of the recorded durations of suites,
+// which are all of the timings a balanced split depends on. Shards that
+// print different digests may have split the suites differently.
+func timingsDigest(suites []string, timings *suiteTimings) string {
+	paths := make([]string, 0, len(suites))
+	for _, suitePath := range suites {
+		paths = append(paths, filepath.Clean(suitePath))
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, suitePath := range paths {
+		if seconds, ok := timings.Suites[suitePath]; ok {
+			fmt.Fprintf(h, "%s\x00%s\n", suitePath, strconv.FormatFloat(seconds, 'g', -1, 64))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// longestFirst returns suites ordered by expected duration, longest first,
+// and by path among equals.
+func longestFirst(suites []string, durations map[string]time.Duration) []string {
+	order := append([]string(nil), suites...)
+	sort.SliceStable(order, func(i, j int) bool {
+		if durations[order[i]] != durations[order[j]] {
+			return durations[order[i]] > durations[order[j]]
+		}
+		return order[i] < order[j]
+	})
+	return order
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseShard(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    shard
+		wantErr bool
+	}{
+		{in: "1/1", want: shard{index: 1, count: 1}},
+		{in: "2/4", want: shard{index: 2, count: 4}},
+		{in: " 3 / 4 ", want: shard{index: 3, count: 4}},
+		{in: "0/4", wantErr: true},
+		{in: "5/4", wantErr: true},
+		{in: "1/0", wantErr: true},
+		{in: "-1/4", wantErr: true},
+		{in: "2", wantErr: true},
+		{in: "a/b", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseShard(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseShard(%q) = %+v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseShard(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardSuites(t *testing.T) {
+	suites := []string{"fixtures/a", "fixtures/b", "fixtures/c", "fixtures/d", "fixtures/e", "fixtures/f", "fixtures/g"}
+	tests := []struct {
+		name     string
+		count    int
+		timings  map[string]float64
+		balanced bool
+		want     [][]string // Suites of each shard, if checked
+	}{
+		{
+			name:  "hash without timings",
+			count: 3,
+		},
+		{
+			name:    "hash with timings of other suites only",
+			count:   3,
+			timings: map[string]float64{"fixtures/z": 10},
+		},
+		{
+			name:     "balanced by durations",
+			count:    2,
+			timings:  map[string]float64{"fixtures/a": 10, "fixtures/b": 6, "fixtures/c": 5, "fixtures/d": 4, "fixtures/e": 3, "fixtures/f": 1, "fixtures/g": 1},
+			balanced: true,
+			// a=10 | b=6, c=5 (11) | d=4 to a (14) | e=3 to b (14) | f=1 to a | g=1 to b
+			want: [][]string{
+				{"fixtures/a", "fixtures/d", "fixtures/f"},
+				{"fixtures/b", "fixtures/c", "fixtures/e", "fixtures/g"},
+			},
+		},
+		{
+			name:     "missing suites count as the average",
+			count:    2,
+			timings:  map[string]float64{"fixtures/a": 12, "fixtures/b": 2},
+			balanced: true,
+		},
+		{
+			name:     "more shards than suites",
+			count:    9,
+			timings:  map[string]float64{"fixtures/a": 1},
+			balanced: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timings := &suiteTimings{Suites: tt.timings}
+			if timings.Suites == nil {
+				timings.Suites = make(map[string]float64)
+			}
+
+			seen := make(map[string]int)
+			for i := 1; i <= tt.count; i++ {
+				got, _, balanced := shardSuites(suites, shard{index: i, count: tt.count}, timings)
+				if balanced != tt.balanced {
+					t.Errorf("shard %d: balanced = %v, want %v", i, balanced, tt.balanced)
+				}
+				if !slices.IsSortedFunc(got, func(a, b string) int { return slices.Index(suites, a) - slices.Index(suites, b) }) {
+					t.Errorf("shard %d: %q are not in suite order", i, got)
+				}
+				if tt.want != nil && !slices.Equal(got, tt.want[i-1]) {
+					t.Errorf("shard %d: got %q, want %q", i, got, tt.want[i-1])
+				}
+				for _, suitePath := range got {
+					seen[suitePath]++
+				}
+			}
+			for _, suitePath := range suites {
+				if seen[suitePath] != 1 {
+					t.Errorf("%s is in %d shards, want 1", suitePath, seen[suitePath])
+				}
+			}
+
+			// Every job must compute the same split
+			first, _, _ := shardSuites(suites, shard{index: 1, count: tt.count}, timings)
+			again, _, _ := shardSuites(suites, shard{index: 1, count: tt.count}, timings)
+			if !slices.Equal(first, again) {
+				t.Errorf("split changed between calls: %q, then %q", first, again)
+			}
+		})
+	}
+}
+
+func TestShardSuitesExpectedDuration(t *testing.T) {
+	timings := &suiteTimings{Suites: map[string]float64{"a": 4, "b": 3, "c": 2}}
+	_, expected, _ := shardSuites([]string{"a", "b", "c"}, shard{index: 2, count: 2}, timings)
+	if want := 5 * time.Second; expected != want {
+		t.Errorf("expected duration %v, want %v", expected, want)
+	}
+}
+
+func TestLongestFirst(t *testing.T) {
+	tests := []struct {
+		name      string
+		suites    []string
+		durations map[string]time.Duration
+		want      []string
+	}{
+		{
+			name:   "no durations keep path order",
+			suites: []string{"c", "a", "b"},
+			want:   []string{"a", "b", "c"},
+		},
+		{
+			name:      "longest first",
+			suites:    []string{"a", "b", "c"},
+			durations: map[string]time.Duration{"a": time.Second, "b": 3 * time.Second, "c": 2 * time.Second},
+			want:      []string{"b", "c", "a"},
+		},
+		{
+			name:      "ties by path",
+			suites:    []string{"d", "c", "b", "a"},
+			durations: map[string]time.Duration{"a": time.Second, "b": 2 * time.Second, "c": 2 * time.Second, "d": time.Second},
+			want:      []string{"b", "c", "a", "d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := longestFirst(tt.suites, tt.durations)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("longestFirst = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimingsDigest(t *testing.T) {
+	suites := []string{"fixtures/b", "fixtures/a"}
+	base := &suiteTimings{Suites: map[string]float64{"fixtures/a": 1.5, "fixtures/b": 2}}
+	digest := timingsDigest(suites, base)
+	if len(digest) != 12 || strings.Trim(digest, "0123456789abcdef") != "" {
+		t.Fatalf("digest %q is not 12 hex digits", digest)
+	}
+
+	tests := []struct {
+		name    string
+		suites  []string
+		timings map[string]float64
+		same    bool
+	}{
+		{name: "suite order", suites: []string{"fixtures/a", "fixtures/b"}, timings: base.Suites, same: true},
+		{name: "other suites' entries", suites: suites, timings: map[string]float64{"fixtures/a": 1.5, "fixtures/b": 2, "fixtures/z": 9}, same: true},
+		{name: "changed duration", suites: suites, timings: map[string]float64{"fixtures/a": 1.6, "fixtures/b": 2}},
+		{name: "missing entry", suites: suites, timings: map[string]float64{"fixtures/a": 1.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := timingsDigest(tt.suites, &suiteTimings{Suites: tt.timings})
+			if (got == digest) != tt.same {
+				t.Errorf("digest %s, base %s; want same = %v", got, digest, tt.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// stateDir holds what the runner remembers between runs. It is relative to
+// the working directory, like the suite paths it records.
+const stateDir = ".test-runner"
+
+// lastRunFile records the failures of the most recent run for --rerun-failed.
+const lastRunFile = "last-run.json"
+
+// lastRun is the content of the last-run file.
+type lastRun struct {
+	Finished time.Time     `json:"finished"`
+	Failed   []failedSuite `json:"failed"`
+}
+
+// failedSuite is a suite that had failures in the last run.
+type failedSuite struct {
+	Path  string   `json:"path"`            // Suite path as it was run
+	Root  string   `json:"root,omitempty"`  // Suite path it was found under, which its config is inherited from
+	Tests []string `json:"tests,omitempty"` // Dirs of its failed tests, relative to the suite
+	Error string   `json:"error,omitempty"` // Why the suite could not run, if it could not
+}
+
+// newLastRun collects failed tests by suite. A suite with an error is rerun
+// as a whole. roots holds the suite path each suite was found under.
+func newLastRun(failedTests map[string][]string, suiteErrors map[string]error, roots map[string]string) lastRun {
+	bySuite := make(map[string]*failedSuite)
+	get := func(path string) *failedSuite {
+		if s, ok := bySuite[path]; ok {
+			return s
+		}
+		s := &failedSuite{Path: path, Root: roots[path]}
+		bySuite[path] = s
+		return s
+	}
+	for path, dirs := range failedTests {
+		s := get(path)
+		seen := make(map[string]bool)
+		for _, dir := range dirs {
+			if !seen[dir] {
+				seen[dir] = true
+				s.Tests = append(s.Tests, dir)
+			}
+		}
+		sort.Strings(s.Tests)
+	}
+	for path, err := range suiteErrors {
+		s := get(path)
+		s.Tests = nil
+		s.Error = err.Error()
+	}
+
+	state := lastRun{Finished: time.Now().UTC(), Failed: []failedSuite{}}
+	for _, s := range bySuite {
+		state.Failed = append(state.Failed, *s)
+	}
+	sort.Slice(state.Failed, func(i, j int) bool {
+		return state.Failed[i].Path < state.Failed[j].Path
+	})
+	return state
+}
+
+// saveLastRun writes state to the last-run file, replacing the previous one.
+func saveLastRun(state lastRun) (string, error) {
+	path := filepath.Join(stateDir, lastRunFile)
+	return path, writeJSONFile(path, state)
+}
+
+// loadLastRun reads the last-run file.
+func loadLastRun() (*lastRun, error) {
+	path := filepath.Join(stateDir, lastRunFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no previous run recorded in %s", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state lastRun
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// timingsFile records how long each suite took, for --shard and scheduling.
+const timingsFile = "timings.json"
+
+// suiteTimings is the content of the timings file.
+type suiteTimings struct {
+	Updated time.Time          `json:"updated"`
+	Suites  map[string]float64 `json:"suites"` // Suite path -> seconds its latest run took
+}
+
+// loadTimings reads the timings file at path. A missing file yields empty
+// timings.
+func loadTimings(path string) (*suiteTimings, error) {
+	timings := &suiteTimings{Suites: make(map[string]float64)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return timings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, timings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if timings.Suites == nil {
+		timings.Suites = make(map[string]float64)
+	}
+	return timings, nil
+}
+
+// duration returns the recorded duration of a suite, if there is one.
+func (t *suiteTimings) duration(suitePath string) (time.Duration, bool) {
+	seconds, ok := t.Suites[filepath.Clean(suitePath)]
+	return time.Duration(seconds * float64(time.Second)), ok
+}
+
+// saveTimings records durations in the timings file at path, keeping the
+// entries of suites that did not run.
+func saveTimings(path string, durations map[string]time.Duration) error {
+	timings, err := loadTimings(path)
+	if err != nil {
+		return err
+	}
+	for suitePath, d := range durations {
+		timings.Suites[filepath.Clean(suitePath)] = d.Round(time.Millisecond).Seconds()
+	}
+	timings.Updated = time.Now().UTC()
+	return writeJSONFile(path, timings)
+}
+
+// writeJSONFile writes v to path as indented JSON, creating its directory.
+// The file is replaced atomically, so a concurrent reader never sees half of
+// it.
+func writeJSONFile(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
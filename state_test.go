@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewLastRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		failedTests map[string][]string
+		suiteErrors map[string]error
+		roots       map[string]string
+		want        []failedSuite
+	}{
+		{
+			name: "no failures",
+			want: []failedSuite{},
+		},
+		{
+			name: "failed tests are sorted and deduplicated",
+			failedTests: map[string][]string{
+				"fixtures/ledger": {"002_Post", "001_Create", "002_Post"},
+				"fixtures/fx":     {"003_Convert"},
+			},
+			roots: map[string]string{"fixtures/ledger": "fixtures"},
+			want: []failedSuite{
+				{Path: "fixtures/fx", Tests: []string{"003_Convert"}},
+				{Path: "fixtures/ledger", Root: "fixtures", Tests: []string{"001_Create", "002_Post"}},
+			},
+		},
+		{
+			name:        "a suite error reruns the whole suite",
+			failedTests: map[string][]string{"fixtures/ledger": {"001_Create"}},
+			suiteErrors: map[string]error{"fixtures/ledger": errors.New("teardown failed")},
+			want: []failedSuite{
+				{Path: "fixtures/ledger", Error: "teardown failed"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newLastRun(tt.failedTests, tt.suiteErrors, tt.roots)
+			if got.Finished.IsZero() {
+				t.Error("Finished is not set")
+			}
+			if !reflect.DeepEqual(got.Failed, tt.want) {
+				t.Errorf("Failed = %+v, want %+v", got.Failed, tt.want)
+			}
+		})
+	}
+}
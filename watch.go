@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twisp/test-runner/runner"
+)
+
+// watchInterval is how often --watch looks for changed fixture files.
+const watchInterval = 500 * time.Millisecond
+
+// watchedExts are the fixture files --watch reacts to: requests, expected
+// responses, variables, case tables, transforms and suite.yaml.
+var watchedExts = map[string]bool{
+	".gql":   true,
+	".json":  true,
+	".jsonl": true,
+	".jq":    true,
+	".yaml":  true,
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchTarget is what a suite's results depend on: its own directory, and
+// the files outside it that apply to its tests. All paths are absolute.
+type watchTarget struct {
+	suite     string   // Suite path as given on the command line
+	dir       string   // Suite directory, watched recursively
+	ancestors []string // Directories between the suite root and the suite, whose suite.yaml and transform.jq apply
+	files     []string // Transform files named by suite.yaml files
+	libraries []string // jq module directories, watched recursively
+}
+
+// newWatchTarget returns the watch target of the suite at suitePath, found
+// under root. jqLibrary is the --jq-library directory, if any. The suite is
+// discovered to find the transforms and jq libraries its suite.yaml files
+// name; if that fails, only its directories are watched.
+func newWatchTarget(suitePath, root, jqLibrary string) watchTarget {
+	dir, _ := filepath.Abs(suitePath)
+	target := watchTarget{suite: suitePath, dir: dir}
+	if absRoot, err := filepath.Abs(root); err == nil && dir != absRoot && within(absRoot, dir) {
+		for d := filepath.Dir(dir); ; d = filepath.Dir(d) {
+			target.ancestors = append(target.ancestors, d)
+			if d == absRoot || d == filepath.Dir(d) {
+				break
+			}
+		}
+	}
+
+	files := make(map[string]bool)
+	libraries := make(map[string]bool)
+	if jqLibrary != "" {
+		if lib, err := filepath.Abs(jqLibrary); err == nil {
+			libraries[lib] = true
+		}
+	}
+	var add func(test *runner.Test)
+	add = func(test *runner.Test) {
+		if test == nil {
+			return
+		}
+		for _, file := range test.Transform {
+			files[file] = true
+		}
+		if test.Config != nil {
+			for _, lib := range test.Config.JQLibraries {
+				libraries[lib] = true
+			}
+		}
+		for _, step := range test.Setup {
+			add(step)
+		}
+		for _, step := range test.Teardown {
+			add(step)
+		}
+	}
+	if suites, err := runner.DiscoverTestsUnder(root, suitePath); err == nil {
+		for _, suite := range suites {
+			add(suite.Base)
+			for _, step := range suite.Setup {
+				add(step)
+			}
+			for _, step := range suite.Teardown {
+				add(step)
+			}
+		}
+	}
+
+	for file := range files {
+		if file, err := filepath.Abs(file); err == nil && !within(dir, file) {
+			target.files = append(target.files, file)
+		}
+	}
+	for lib := range libraries {
+		if lib, err := filepath.Abs(lib); err == nil {
+			target.libraries = append(target.libraries, lib)
+		}
+	}
+	sort.Strings(target.files)
+	sort.Strings(target.libraries)
+	return target
+}
+
+// affects reports whether a change to file, an absolute path, may change
+// the results of the target's suite.
+func (t watchTarget) affects(file string) bool {
+	if within(t.dir, file) || slices.Contains(t.files, file) {
+		return true
+	}
+	if name := filepath.Base(file); name == runner.SuiteConfigFile || name == runner.TransformFile {
+		if slices.Contains(t.ancestors, filepath.Dir(file)) {
+			return true
+		}
+	}
+	for _, lib := range t.libraries {
+		if within(lib, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether path is dir or below it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// snapshotFixtures returns the stamps of the files the targets depend on.
+// Hidden files, such as editor swap files, are ignored.
+func snapshotFixtures(targets []watchTarget) map[string]fileStamp {
+	files := make(map[string]fileStamp)
+	for _, target := range targets {
+		walkFixtures(files, target.dir)
+		for _, lib := range target.libraries {
+			walkFixtures(files, lib)
+		}
+		for _, dir := range target.ancestors {
+			statFixture(files, filepath.Join(dir, runner.SuiteConfigFile))
+			statFixture(files, filepath.Join(dir, runner.TransformFile))
+		}
+		for _, file := range target.files {
+			statFixture(files, file)
+		}
+	}
+	return files
+}
+
+// walkFixtures adds the stamps of the fixture files under root to files.
+func walkFixtures(files map[string]fileStamp, root string) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !watchedExts[filepath.Ext(path)] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+}
+
+// statFixture adds the stamp of the file at path to files, if it exists.
+func statFixture(files map[string]fileStamp, path string) {
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		files[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+}
+
+// changedFiles returns the files added, removed or modified between two
+// snapshots, sorted.
+func changedFiles(before, after map[string]fileStamp) []string {
+	var changed []string
+	for path, stamp := range after {
+		if old, ok := before[path]; !ok || old != stamp {
+			changed = append(changed, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// affectedSuites returns the suites of targets affected by any of files,
+// in the order of targets.
+func affectedSuites(targets []watchTarget, files []string) []string {
+	var affected []string
+	for _, target := range targets {
+		for _, file := range files {
+			if target.affects(file) {
+				affected = append(affected, target.suite)
+				break
+			}
+		}
+	}
+	return affected
+}
+
+// displayPath returns path relative to the working directory when it is
+// below it.
+func displayPath(path string) string {
+	if wd, err := os.Getwd(); err == nil && within(wd, path) {
+		if rel, err := filepath.Rel(wd, path); err == nil {
+			return rel
+		}
+	}
+	return path
+}
+
+// watchSuites polls the files suites depend on until ctx is done and calls
+// rerun for each suite affected by a change. target returns what a suite
+// depends on, and is asked again after the suite reruns, since its
+// suite.yaml may have changed. Changes are collected until the files stop
+// changing, so an editor saving several files triggers one rerun.
+func watchSuites(ctx context.Context, suites []string, target func(suitePath string) watchTarget, rerun func(suitePath string)) {
+	fmt.Printf("\nWatching %d suite(s) for changes (Ctrl-C to stop)\n", len(suites))
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	targets := make([]watchTarget, len(suites))
+	for i, suitePath := range suites {
+		targets[i] = target(suitePath)
+	}
+	current := snapshotFixtures(targets)
+	var pending []string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		next := snapshotFixtures(targets)
+		changed := changedFiles(current, next)
+		current = next
+		if len(changed) > 0 {
+			pending = append(pending, changed...)
+			continue
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		files := pending
+		pending = nil
+		affected := affectedSuites(targets, files)
+		if len(affected) == 0 {
+			continue
+		}
+
+		fmt.Printf("\n[%s] Changed:\n", time.Now().Format("15:04:05"))
+		seen := make(map[string]bool)
+		for _, file := range files {
+			if !seen[file] {
+				seen[file] = true
+				fmt.Printf("  %s\n", displayPath(file))
+			}
+		}
+		runner.RefreshTransformLibraries()
+		for _, suitePath := range affected {
+			if ctx.Err() != nil {
+				return
+			}
+			rerun(suitePath)
+			i := slices.Index(suites, suitePath)
+			targets[i] = target(suitePath)
+		}
+
+		// Files a suite newly depends on are watched from now on, while
+		// files written while the suites ran are picked up on the next poll
+		for path, stamp := range snapshotFixtures(targets) {
+			if _, ok := current[path]; !ok {
+				current[path] = stamp
+			}
+		}
+		fmt.Printf("\nWatching %d suite(s) for changes (Ctrl-C to stop)\n", len(suites))
+	}
+}
+
+// rerunSuite runs a suite again for --watch, in a tenant salted as
+// rerunSalt says, against the first endpoint or container of the run. A suite that asks for an isolated
+// container gets a new one from containerOpts, removed after the rerun;
+// containerOpts is nil when the run uses an external endpoint. With
+// --summary only the result and any failures are printed.
+func rerunSuite(ctx context.Context, suitePath, suiteRoot, accountSalt string, run settings, options runner.Options, headers map[string]string, endpoint string, containers []*runner.TwispContainer, containerOpts *runner.ContainerOptions) {
+	adminURL, grpcAddr := run.adminEndpoint, run.grpcEndpoint
+	if len(containers) > 0 {
+		adminURL, grpcAddr = containers[0].AdminURL, containers[0].GRPCAddr
+	}
+
+	suiteConfig, err := runner.LoadSuiteConfig(suiteRoot, suitePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if suiteConfig.Isolated {
+		if containerOpts == nil {
+			fmt.Fprintf(os.Stderr, "Warning: suite %q asks for an isolated container; running it against %s\n", suitePath, endpoint)
+		} else {
+			fmt.Printf("\nStarting isolated container for suite: %s\n", suitePath)
+			isolated, err := runner.StartTwispContainer(ctx, *containerOpts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: starting isolated container for suite %q: %v\n", suitePath, err)
+				return
+			}
+			defer terminateContainers([]*runner.TwispContainer{isolated})
+			endpoint = isolated.GraphQLURL
+			adminURL, grpcAddr = isolated.AdminURL, isolated.GRPCAddr
+		}
+	}
+
+	accountID := hashSuitePath(suitePath, rerunSalt(accountSalt, run.replay != ""))
+	r := runner.NewRunner(endpoint, options, accountID, headers)
+	defer r.Close()
+	r.SetServiceEndpoints(adminURL, grpcAddr)
+	r.SetSuiteRoot(suiteRoot)
+	if run.summary {
+		r.SetOutput(io.Discard)
+	}
+
+	result, err := r.RunSuite(ctx, suitePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: running suite %q: %v\n", suitePath, err)
+		return
+	}
+	if !run.summary {
+		return
+	}
+
+	for _, tr := range result.Results {
+		if tr.Passed {
+			continue
+		}
+		fmt.Printf("  FAIL  %s\n", filepath.Join(suitePath, tr.Name()))
+		if tr.Error != nil {
+			fmt.Printf("        %v\n", tr.Error)
+		}
+	}
+	fmt.Printf("%s: %d passed, %d failed, %d skipped (%v)\n", suitePath, result.Passed, result.Failed, result.Skipped, result.Duration.Round(time.Millisecond))
+}
+
+// rerunSalt returns the salt of the tenant a --watch rerun uses. Live, that
+// is a fresh tenant on top of the run's salt, so reruns do not see each
+// other's data. A --replay recording only answers the tenants of the run it
+// was made from, so there the run's own tenant is used again.
+func rerunSalt(accountSalt string, replay bool) string {
+	if replay {
+		return accountSalt
+	}
+	return accountSalt + "\x00" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAffectedSuites(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"suite.yaml":                      "jq_library: lib\n",
+		"transform.jq":                    "del(.created)\n",
+		"lib/ids.jq":                      "def id: .id;\n",
+		"shared/strip.jq":                 "del(.updated)\n",
+		"ledger/request.gql":              "query { ledger }",
+		"ledger/response.json":            `{"data": {}}`,
+		"ledger/suite.yaml":               "transform: ../shared/strip.jq\n",
+		"group/fx/request.gql":            "query { fx }",
+		"group/fx/response.json":          `{"data": {}}`,
+		"group/suite.yaml":                "headers:\n  x-test: fx\n",
+		"group/fx/001_Convert/notes.json": "{}",
+		"other/unrelated.jq":              ".",
+	} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ledger := filepath.Join(root, "ledger")
+	fx := filepath.Join(root, "group", "fx")
+	globalLib := t.TempDir()
+	targets := []watchTarget{
+		newWatchTarget(ledger, root, globalLib),
+		newWatchTarget(fx, root, globalLib),
+	}
+
+	tests := []struct {
+		name string
+		file string
+		want []string
+	}{
+		{name: "file in the suite", file: "ledger/request.gql", want: []string{ledger}},
+		{name: "file in a nested test", file: "group/fx/001_Convert/notes.json", want: []string{fx}},
+		{name: "root suite.yaml", file: "suite.yaml", want: []string{ledger, fx}},
+		{name: "root transform", file: "transform.jq", want: []string{ledger, fx}},
+		{name: "intermediate suite.yaml", file: "group/suite.yaml", want: []string{fx}},
+		{name: "new intermediate transform", file: "group/transform.jq", want: []string{fx}},
+		{name: "transform named by suite.yaml", file: "shared/strip.jq", want: []string{ledger}},
+		{name: "jq_library module", file: "lib/ids.jq", want: []string{ledger, fx}},
+		{name: "--jq-library module", file: filepath.Join(globalLib, "dates.jq"), want: []string{ledger, fx}},
+		{name: "unrelated file", file: "other/unrelated.jq"},
+		{name: "other fixture in an ancestor", file: "group/notes.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := tt.file
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(root, file)
+			}
+			got := affectedSuites(targets, []string{file})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("affected %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRerunSalt(t *testing.T) {
+	// A recording only answers the tenants of the run it was made from
+	for _, salt := range []string{"", "run"} {
+		if got := rerunSalt(salt, true); got != salt {
+			t.Errorf("replayed rerun salt = %q, want the run's %q", got, salt)
+		}
+	}
+
+	first := rerunSalt("run", false)
+	time.Sleep(time.Millisecond)
+	second := rerunSalt("run", false)
+	if first == "run" || first == second {
+		t.Errorf("live rerun salts %q and %q, want two fresh tenants", first, second)
+	}
+	if !strings.HasPrefix(first, "run") {
+		t.Errorf("live rerun salt %q does not build on the run's", first)
+	}
+}